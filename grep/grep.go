@@ -2,112 +2,273 @@ package grep
 
 import (
 	"bytes"
-	"fmt"
 	"io"
-	"log"
+	"io/ioutil"
 	"os"
-	"regexp"
+	"strings"
 
-	"github.com/daviddengcn/go-colortext"
 	"github.com/daviddengcn/go-villa"
 	"github.com/daviddengcn/sgrep/parser"
+	_ "github.com/daviddengcn/sgrep/parser/brace"
 	_ "github.com/daviddengcn/sgrep/parser/go"
 	"github.com/daviddengcn/sgrep/parser/indent"
+	_ "github.com/daviddengcn/sgrep/parser/ini"
 	_ "github.com/daviddengcn/sgrep/parser/json"
+	_ "github.com/daviddengcn/sgrep/parser/python"
+	_ "github.com/daviddengcn/sgrep/parser/toml"
 	_ "github.com/daviddengcn/sgrep/parser/xml"
+	_ "github.com/daviddengcn/sgrep/parser/yaml"
 )
 
-func markAndPrint(ln int, re *regexp.Regexp, line []byte) {
-	locs := re.FindAllIndex(line, -1)
-	if len(locs) > 0 {
-		fmt.Printf("%4d: ", ln)
-	} else {
-		fmt.Print("      ")
+// Matcher finds the matches of a pattern. *regexp.Regexp is a Matcher.
+type Matcher interface {
+	// FindAllIndex returns the [start, end) offsets of the successive matches
+	// in b. At most n matches are returned unless n < 0.
+	FindAllIndex(b []byte, n int) [][]int
+}
+
+// Line is a line of the source.
+type Line struct {
+	// 1-based
+	Line int
+	// Offset of the start of the line in the source.
+	Offs int
+	// Text of the line without the line break.
+	Text []byte
+	// [start, end) offsets in Text of the matches.
+	Matches [][]int
+}
+
+// Omitted is a summary of consecutive sibling blocks without matches.
+type Omitted struct {
+	Blocks int
+	Lines  int
+	// Leading white spaces of the first omitted line.
+	Indent string
+}
+
+// Level is a level enclosing matches.
+type Level struct {
+	Header sparser.Range
+	Footer sparser.Range
+	// The node reported by the parser. Empty if not reported.
+	Node sparser.Node
+
+	HeaderLines []Line
+	// Footer lines are filled when the level ends.
+	FooterLines []Line
+
+	// Sibling blocks omitted before the header.
+	OmittedBefore *Omitted
+	// Sub blocks omitted before the footer. Filled when the level ends.
+	OmittedAfter *Omitted
+
+	// The range from the header to the footer. Filled when the level ends.
+	Extent sparser.Range
+	buffer []byte
+}
+
+// rangeText returns the text of r in lines, which should cover r.
+func rangeText(lines []Line, r sparser.Range) string {
+	if len(lines) == 0 {
+		return ""
 	}
-	p := 0
-	for _, loc := range locs {
-		if loc[0] > p {
-			os.Stdout.Write(line[p:loc[0]])
+	var text []byte
+	for i, line := range lines {
+		if i > 0 {
+			text = append(text, '\n')
 		}
-		ct.ChangeColor(ct.Green, true, ct.None, false)
-		os.Stdout.Write(line[loc[0]:loc[1]])
-		ct.ResetColor()
-		p = loc[1]
+		text = append(text, line.Text...)
+	}
+	start, end := r.MinOffs-lines[0].Offs, r.MaxOffs+1-lines[0].Offs
+	if start < 0 {
+		start = 0
 	}
-	if p < len(line) {
-		os.Stdout.Write(line[p:])
+	if end > len(text) {
+		end = len(text)
 	}
-	fmt.Println()
+	if start > end {
+		return ""
+	}
+	return string(text[start:end])
 }
 
-type LevelInfo struct {
-	headerPrinted bool
-	headerBuffer  []byte
-	header        sparser.Range
-
-	hiddenChidren int
-	hiddenLines   int
-	found         bool
+// Lines returns all the lines of the level, with matches of m. m could be nil.
+func (l *Level) Lines(m Matcher) []Line {
+	return linesOfRange(m, l.buffer, l.Extent, false)
 }
 
-type Receiver struct {
-	fn        villa.Path
-	fnPrinted bool
-	re        *regexp.Regexp
-	// 1-based
-	maxPrintedLine int
-	infos          []LevelInfo
+// HeaderText returns the text of the header.
+func (l *Level) HeaderText() string {
+	return rangeText(l.HeaderLines, l.Header)
 }
 
-func (rcvr *Receiver) beforeBody(level int) {
-	info := rcvr.infos[level]
-	if !info.headerPrinted {
-		rcvr.beforeBody(level - 1)
+// Crumb returns a short name of the level derived from the first line of its
+// header, e.g. "project" for `<project xmlns="...">`, "func (s *Server) Serve"
+// for `func (s *Server) Serve(l net.Listener) error {` and "numbers" for
+// `"numbers": [`. For a level without header, the kind and the name of its
+// node are returned, e.g. "type Server". If the node has a name, the last
+// line containing it is used instead, skipping e.g. decorators. Returns "" if
+// nothing is left.
+func (l *Level) Crumb() string {
+	text := strings.TrimSpace(l.HeaderText())
+	if text == "" && l.Node.Name != "" {
+		return l.Node.Kind + " " + l.Node.Name
+	}
+	if lines := strings.Split(text, "\n"); len(lines) > 1 {
+		text = lines[0]
+		if l.Node.Name != "" {
+			for i := len(lines) - 1; i >= 0; i-- {
+				if containsWord(lines[i], l.Node.Name) {
+					text = lines[i]
+					break
+				}
+			}
+		}
+		text = strings.TrimSpace(text)
+	}
+	if strings.HasPrefix(text, "<") {
+		// an XML tag
+		text = text[1:]
+		if i := strings.IndexAny(text, " \t/>"); i >= 0 {
+			text = text[:i]
+		}
+		return text
+	}
+	// cut the parameters, i.e. a '(' right after a word
+	for i := 1; i < len(text); i++ {
+		if text[i] == '(' && isWordByte(text[i-1]) {
+			text = text[:i]
+			break
+		}
+	}
+	text = strings.TrimRight(text, " \t{[(:=")
+	if len(text) >= 2 && text[0] == '"' && text[len(text)-1] == '"' {
+		text = text[1 : len(text)-1]
+	}
+	return text
+}
 
-		// Print the header
-		rcvr.showRange(info.headerBuffer, info.header)
-		info.headerPrinted = true
-	} else if !rcvr.fnPrinted {
-		fmt.Println(rcvr.fn)
-		rcvr.fnPrinted = true
+// containsWord returns true if word is in s, not as a part of a longer word.
+func containsWord(s, word string) bool {
+	for i := 0; ; {
+		j := strings.Index(s[i:], word)
+		if j < 0 {
+			return false
+		}
+		j += i
+		end := j + len(word)
+		if (j == 0 || !isWordByte(s[j-1])) && (end == len(s) || !isWordByte(s[end])) {
+			return true
+		}
+		i = j + 1
 	}
 }
 
-func (rcvr *Receiver) StartLevel(buffer []byte, header sparser.Range) error {
-	rcvr.infos = append(rcvr.infos, LevelInfo{
-		headerBuffer: buffer,
-		header:       header,
-	})
-	info := &rcvr.infos[len(rcvr.infos)-1]
+func isWordByte(b byte) bool {
+	return b == '_' || b >= '0' && b <= '9' || b >= 'a' && b <= 'z' || b >= 'A' && b <= 'Z'
+}
 
-	if findInBuffer(rcvr.re, buffer, header) {
-		info.found = true
-		rcvr.beforeBody(len(rcvr.infos) - 1)
-	}
+// Places where a Result is found.
+const (
+	IN_BLOCK = iota
+	IN_HEADER
+	IN_FOOTER
+)
 
-	return nil
+// Result is a block, header or footer containing matches.
+type Result struct {
+	// The enclosing levels, from the outermost to the innermost. For a match
+	// in a header or footer, the last one is the level itself.
+	Levels []*Level
+	// One of IN_BLOCK, IN_HEADER and IN_FOOTER. Lines in the doc of the
+	// level are IN_HEADER too, and before the header lines.
+	In int
+	// The lines with matches.
+	Lines []Line
+
+	// Sibling blocks omitted before the block, for IN_BLOCK.
+	OmittedBefore *Omitted
 }
 
-func (rcvr *Receiver) EndLevel(buffer []byte, footer sparser.Range) error {
-	info := rcvr.infos[len(rcvr.infos)-1]
+type LevelInfo struct {
+	headerBuffer []byte
+	header       sparser.Range
+	node         sparser.Node
+	// Created when the first match inside the level is found.
+	level *Level
+
+	// Number of direct sub levels and final blocks.
+	children int
+	// The range from the header to the footer seen so far, and its buffer.
+	extent       sparser.Range
+	extentBuffer []byte
+	// The max line number of the sub blocks shown or counted as hidden.
+	lastLine int
+	// Leading white spaces of the first hidden line.
+	hiddenIndent string
+
+	// For a Query, whether each pattern is seen inside the level.
+	seen []bool
+	// For a Query, the results inside the level, kept until the level ends.
+	pending []*Result
+	// For a Query, whether any sub level is selected.
+	subSelected bool
+	// For a Query, the results of the selected sub levels, kept until the
+	// level ends.
+	selected []*Result
+	// For a Query, the results of the selected sub levels which are inside a
+	// scope node, i.e. no longer ruled out by any None pattern.
+	scoped []*Result
+	// For a Query, whether any of the None patterns is seen in the level
+	// outside its sub levels.
+	noneDirect bool
+
+	// Number of Receiver.Within matched by the headers of this level and its
+	// ancestors.
+	within int
 
-	info.found = info.found || findInBuffer(rcvr.re, buffer, footer)
-	if info.found {
-		rcvr.beforeBody(len(rcvr.infos) - 1)
-		rcvr.showRange(buffer, footer)
+	hiddenChidren int
+	hiddenLines   int
+	found         bool
+}
 
-		rcvr.infos[len(rcvr.infos)-2].found = true
-	}
+// Query selects the innermost levels containing all of the All patterns and
+// none of the None patterns, unless any of the None patterns is found in an
+// enclosing level up to the nearest scope node, e.g. a function. Without an
+// enclosing scope node, only None patterns found directly in an enclosing
+// level, i.e. outside its sub levels, rule it out.
+type Query struct {
+	All  []Matcher
+	None []Matcher
+}
 
-	rcvr.infos = rcvr.infos[:len(rcvr.infos)-1]
-	return nil
+// Receiver is a sparser.Receiver collecting the Results of a Matcher.
+type Receiver struct {
+	m Matcher
+	// If set, final blocks without any match are collected instead. Levels
+	// without any sub levels or final blocks are taken as final blocks.
+	Invert bool
+	// If set, only the results inside the levels selected by the Query are
+	// collected. The Matcher is expected to match any of the Query.All.
+	Query *Query
+	// If set, only matches inside levels whose headers are matched by the
+	// Matchers are collected. The levels are searched from the outermost to
+	// the innermost, in order, not necessarily directly nested.
+	Within []Matcher
+
+	infos   []LevelInfo
+	Results []*Result
 }
 
-func findInBuffer(re *regexp.Regexp, buffer []byte, r sparser.Range) bool {
-	if r.IsEmpty() {
-		return false
+// NewReceiver returns a Receiver collecting the matches of m.
+func NewReceiver(m Matcher) *Receiver {
+	return &Receiver{
+		m: m,
+		infos: []LevelInfo{
+			LevelInfo{},
+		},
 	}
-	return re.FindIndex(buffer[r.MinOffs:r.MaxOffs+1]) != nil
 }
 
 func relocateLineStart(buffer []byte, offs int) int {
@@ -116,6 +277,16 @@ func relocateLineStart(buffer []byte, offs int) int {
 	return offs
 }
 
+// indentOf returns the leading white spaces of the line containing offs.
+func indentOf(buffer []byte, offs int) string {
+	start := relocateLineStart(buffer, offs)
+	end := start
+	for end < len(buffer) && (buffer[end] == ' ' || buffer[end] == '\t') {
+		end++
+	}
+	return string(buffer[start:end])
+}
+
 func findLineEnd(buffer []byte, offs int) int {
 	l := bytes.IndexByte(buffer[offs:], '\n')
 	if l < 0 {
@@ -125,123 +296,465 @@ func findLineEnd(buffer []byte, offs int) int {
 	return offs + l
 }
 
-func (rcvr *Receiver) markAndPrint(line int, buffer []byte) {
-	markAndPrint(line, rcvr.re, buffer)
-}
+// linesOfRange returns the lines in r with the matches of m inside r. If
+// matchedOnly is true, lines without any match are skipped.
+func linesOfRange(m Matcher, buffer []byte, r sparser.Range, matchedOnly bool) []Line {
+	if r.IsEmpty() {
+		return nil
+	}
+	var lines []Line
+	offs := relocateLineStart(buffer, r.MinOffs)
+	for line := r.MinLine; line <= r.MaxLine && offs <= r.MaxOffs; line++ {
+		end := findLineEnd(buffer, offs)
 
-// Returns the start of next line
-func (rcvr *Receiver) showLine(buffer []byte, offs int, line int) int {
-	end := findLineEnd(buffer, offs)
+		// only the part inside r is searched
+		start, stop := offs, end
+		if start < r.MinOffs {
+			start = r.MinOffs
+		}
+		if stop > r.MaxOffs+1 {
+			stop = r.MaxOffs + 1
+		}
+		var matches [][]int
+		if m != nil {
+			matches = m.FindAllIndex(buffer[start:stop], -1)
+		}
+		if len(matches) > 0 || !matchedOnly {
+			for _, loc := range matches {
+				loc[0], loc[1] = loc[0]+start-offs, loc[1]+start-offs
+			}
+			lines = append(lines, Line{
+				Line:    line,
+				Offs:    offs,
+				Text:    append([]byte(nil), buffer[offs:end]...),
+				Matches: matches,
+			})
+		}
 
-	if line > rcvr.maxPrintedLine {
-		rcvr.markAndPrint(line, buffer[offs:end])
-		rcvr.maxPrintedLine = line
+		if end >= len(buffer) {
+			break
+		}
+		// move over \n
+		offs = end + 1
 	}
+	return lines
+}
 
-	if end < len(buffer) {
-		// move over \n
-		end++
+// hide counts a sub block in lines [minLine, maxLine] as hidden. offs is the
+// offset of the block in buffer. Lines shared with previous blocks are not
+// counted, and nor is a block without any new line.
+func (info *LevelInfo) hide(buffer []byte, offs, minLine, maxLine int) {
+	if minLine <= info.lastLine {
+		minLine = info.lastLine + 1
+	}
+	if maxLine < minLine {
+		return
 	}
-	return end
+	if info.hiddenChidren == 0 {
+		info.hiddenIndent = indentOf(buffer, offs)
+	}
+	info.hiddenChidren++
+	info.hiddenLines += maxLine - minLine + 1
+	info.lastLine = maxLine
 }
 
-func (rcvr *Receiver) showRange(buffer []byte, r sparser.Range) {
+// show marks the sub blocks up to maxLine as shown.
+func (info *LevelInfo) show(maxLine int) {
+	if maxLine > info.lastLine {
+		info.lastLine = maxLine
+	}
+}
+
+// extend extends the extent of the level with a range in it.
+func (info *LevelInfo) extend(buffer []byte, r sparser.Range) {
 	if r.IsEmpty() {
 		return
 	}
+	if info.extent.IsEmpty() {
+		info.extent, info.extentBuffer = r, buffer
+		return
+	}
+	if r.MaxOffs > info.extent.MaxOffs {
+		info.extent.MaxOffs = r.MaxOffs
+	}
+	if r.MaxLine > info.extent.MaxLine {
+		info.extent.MaxLine = r.MaxLine
+	}
+}
 
-	offs := relocateLineStart(buffer, r.MinOffs)
-	for line := r.MinLine; line <= r.MaxLine; line++ {
-		offs = rcvr.showLine(buffer, offs, line)
+// takeOmitted returns the summary of the hidden sub blocks since last shown
+// one, and resets the counting. Returns nil if nothing is hidden.
+func (info *LevelInfo) takeOmitted() *Omitted {
+	if info.hiddenChidren == 0 {
+		return nil
+	}
+	o := &Omitted{
+		Blocks: info.hiddenChidren,
+		Lines:  info.hiddenLines,
+		Indent: info.hiddenIndent,
 	}
+	info.hiddenChidren, info.hiddenLines = 0, 0
+	return o
 }
 
-func (rcvr *Receiver) FinalBlock(buffer []byte, body sparser.Range) error {
-	if !findInBuffer(rcvr.re, buffer, body) {
-		// no match, skipped
+// levels returns the Levels of the current stack of levels, except the root.
+func (rcvr *Receiver) levels() []*Level {
+	levels := make([]*Level, 0, len(rcvr.infos)-1)
+	for i := 1; i < len(rcvr.infos); i++ {
+		info := &rcvr.infos[i]
+		if info.level == nil {
+			info.level = &Level{
+				Header:        info.header,
+				Node:          info.node,
+				HeaderLines:   linesOfRange(rcvr.m, info.headerBuffer, info.header, false),
+				OmittedBefore: rcvr.infos[i-1].takeOmitted(),
+			}
+		}
+		levels = append(levels, info.level)
+	}
+	return levels
+}
+
+// inScope returns whether a match in the current level (or its header or
+// footer) is inside the levels required by Within.
+func (rcvr *Receiver) inScope(in int) bool {
+	i := len(rcvr.infos) - 1
+	if in != IN_BLOCK {
+		// a header or footer is inside the parent level
+		i--
+	}
+	return i >= 0 && rcvr.infos[i].within == len(rcvr.Within)
+}
+
+// addResult adds a Result in the current level. Returns false if the result is
+// out of the scope.
+func (rcvr *Receiver) addResult(in int, lines []Line) bool {
+	if !rcvr.inScope(in) {
+		return false
+	}
+	info := &rcvr.infos[len(rcvr.infos)-1]
+	info.found = true
+	r := &Result{
+		Levels: rcvr.levels(),
+		In:     in,
+		Lines:  lines,
+	}
+	if in == IN_BLOCK {
+		r.OmittedBefore = info.takeOmitted()
+	}
+	if rcvr.Query != nil {
+		info.pending = append(info.pending, r)
+		return true
+	}
+	rcvr.Results = append(rcvr.Results, r)
+	return true
+}
+
+// see marks the Query patterns found in r as seen in the current level.
+func (rcvr *Receiver) see(buffer []byte, r sparser.Range) {
+	if rcvr.Query == nil || r.IsEmpty() {
+		return
+	}
+	info := &rcvr.infos[len(rcvr.infos)-1]
+	if info.seen == nil {
+		info.seen = make([]bool, len(rcvr.Query.All)+len(rcvr.Query.None))
+	}
+	text := buffer[r.MinOffs : r.MaxOffs+1]
+	for i, m := range rcvr.Query.All {
+		info.seen[i] = info.seen[i] || m.FindAllIndex(text, 1) != nil
+	}
+	for i, m := range rcvr.Query.None {
+		if m.FindAllIndex(text, 1) != nil {
+			info.seen[len(rcvr.Query.All)+i] = true
+			info.noneDirect = true
+		}
+	}
+}
+
+// scopeKinds are the kinds of the scope nodes, which bound the enclosing
+// levels whose None patterns rule out a selected level.
+var scopeKinds = map[string]bool{
+	"func": true, "method": true, "def": true, "class": true,
+}
+
+// selectResults passes the results of a selected level to the parent level,
+// or collects them if the parent is the outermost one. Unless scoped, the
+// parent drops them if it rules them out.
+func (rcvr *Receiver) selectResults(parent *LevelInfo, results []*Result, scoped bool) {
+	switch {
+	case len(rcvr.infos) == 1:
+		rcvr.Results = append(rcvr.Results, results...)
+	case scoped:
+		parent.scoped = append(parent.scoped, results...)
+	default:
+		parent.selected = append(parent.selected, results...)
+	}
+	parent.subSelected = true
+}
+
+// rulesOut returns whether the ending level info, which has been popped,
+// rules out its selected sub levels.
+func (rcvr *Receiver) rulesOut(info *LevelInfo) bool {
+	if info.noneDirect {
+		return true
+	}
+	inScope := scopeKinds[info.node.Kind]
+	for i := range rcvr.infos {
+		inScope = inScope || scopeKinds[rcvr.infos[i].node.Kind]
+	}
+	if !inScope || info.seen == nil {
+		return false
+	}
+	for _, seen := range info.seen[len(rcvr.Query.All):] {
+		if seen {
+			return true
+		}
+	}
+	return false
+}
+
+// endQueryLevel decides whether the ending level, which has been popped as
+// info, is selected by the Query. The results inside a selected level are
+// passed to the parent as selected, otherwise as pending.
+func (rcvr *Receiver) endQueryLevel(info *LevelInfo) {
+	parent := &rcvr.infos[len(rcvr.infos)-1]
+	if info.seen != nil {
+		if parent.seen == nil {
+			parent.seen = make([]bool, len(info.seen))
+		}
+		for i, seen := range info.seen {
+			parent.seen[i] = parent.seen[i] || seen
+		}
+	}
+	scoped := scopeKinds[info.node.Kind]
+	if info.subSelected {
+		if len(info.selected) > 0 && !rcvr.rulesOut(info) {
+			rcvr.selectResults(parent, info.selected, scoped)
+		}
+		if len(info.scoped) > 0 {
+			rcvr.selectResults(parent, info.scoped, true)
+		}
+		return
+	}
+
+	selected := info.seen != nil
+	for i, seen := range info.seen {
+		if seen != (i < len(rcvr.Query.All)) {
+			selected = false
+			break
+		}
+	}
+	if selected {
+		rcvr.selectResults(parent, info.pending, scoped)
+	} else {
+		parent.pending = append(parent.pending, info.pending...)
+	}
+}
+
+func (rcvr *Receiver) StartLevel(buffer []byte, header sparser.Range) error {
+	return rcvr.StartNode(buffer, header, sparser.Node{})
+}
+
+func (rcvr *Receiver) StartNode(buffer []byte, header sparser.Range, node sparser.Node) error {
+	parent := &rcvr.infos[len(rcvr.infos)-1]
+	parent.children++
+	within := parent.within
+	if within < len(rcvr.Within) && !header.IsEmpty() &&
+		rcvr.Within[within].FindAllIndex(buffer[header.MinOffs:header.MaxOffs+1], 1) != nil {
+		within++
+	}
+	rcvr.infos = append(rcvr.infos, LevelInfo{
+		headerBuffer: buffer,
+		header:       header,
+		node:         node,
+		within:       within,
+		lastLine:     header.MaxLine,
+	})
+	info := &rcvr.infos[len(rcvr.infos)-1]
+	info.extend(buffer, node.Doc)
+	info.extend(buffer, header)
+	rcvr.see(buffer, node.Doc)
+	rcvr.see(buffer, header)
+
+	if rcvr.Invert {
 		return nil
 	}
+	// matches in the doc are taken as in the header
+	lines := append(linesOfRange(rcvr.m, buffer, node.Doc, true), linesOfRange(rcvr.m, buffer, header, true)...)
+	if len(lines) > 0 {
+		rcvr.addResult(IN_HEADER, lines)
+	}
 
-	rcvr.beforeBody(len(rcvr.infos) - 1)
-	rcvr.infos[len(rcvr.infos)-1].found = true
+	return nil
+}
 
-	if !body.IsEmpty() {
-		offs := body.MinOffs
-		for line := body.MinLine; line <= body.MaxLine; line++ {
-			end := findLineEnd(buffer, offs)
+func (rcvr *Receiver) EndLevel(buffer []byte, footer sparser.Range) error {
+	if len(rcvr.infos) <= 1 {
+		// unbalanced EndLevel, ignored
+		return nil
+	}
 
-			if rcvr.re.FindIndex(buffer[offs:end]) != nil {
-				rcvr.showLine(buffer, relocateLineStart(buffer, offs), line)
-			}
+	if !rcvr.Invert {
+		if lines := linesOfRange(rcvr.m, buffer, footer, true); len(lines) > 0 {
+			rcvr.addResult(IN_FOOTER, lines)
+		}
+	}
+	rcvr.see(buffer, footer)
 
-			if end >= len(buffer) {
-				break
-			}
-			offs = end + 1
+	info := &rcvr.infos[len(rcvr.infos)-1]
+	info.extend(buffer, footer)
+	if info.found {
+		info.level.Footer = footer
+		info.level.Extent = info.extent
+		info.level.buffer = info.extentBuffer
+		info.level.FooterLines = linesOfRange(rcvr.m, buffer, footer, false)
+		info.level.OmittedAfter = info.takeOmitted()
+	}
+
+	rcvr.infos = rcvr.infos[:len(rcvr.infos)-1]
+	parent := &rcvr.infos[len(rcvr.infos)-1]
+	parent.extend(info.extentBuffer, info.extent)
+	if info.found {
+		parent.found = true
+	}
+
+	if rcvr.Query != nil {
+		rcvr.endQueryLevel(info)
+	}
+
+	shown := info.found
+	if rcvr.Invert && info.children == 0 {
+		// a leaf level is taken as a final block
+		lines := linesOfRange(rcvr.m, info.headerBuffer, info.header, false)
+		if len(lines) > 0 && !hasMatches(lines) &&
+			!hasMatches(linesOfRange(rcvr.m, buffer, footer, true)) {
+			shown = rcvr.addResult(IN_BLOCK, lines) || shown
 		}
 	}
+
+	if shown {
+		parent.show(info.extent.MaxLine)
+	} else if !info.extent.IsEmpty() {
+		parent.hide(info.extentBuffer, info.extent.MinOffs, info.extent.MinLine, info.extent.MaxLine)
+	}
 	return nil
 }
 
-// ext doesn't start with '.'
-func Grep(re *regexp.Regexp, fn villa.Path, ext string) {
-	isIndent := false
-	var err error
-	p, err := sparser.New(ext)
-	if err != nil {
-		p = indent.Parser{}
-		isIndent = true
+func hasMatches(lines []Line) bool {
+	for _, line := range lines {
+		if len(line.Matches) > 0 {
+			return true
+		}
 	}
+	return false
+}
 
-	var f io.Reader
-	if fn == "" {
-		f = os.Stdin
+func (rcvr *Receiver) FinalBlock(buffer []byte, body sparser.Range) error {
+	info := &rcvr.infos[len(rcvr.infos)-1]
+	info.children++
+	info.extend(buffer, body)
+	rcvr.see(buffer, body)
+	if body.IsEmpty() {
+		return nil
+	}
+
+	shown := false
+	if rcvr.Invert {
+		if lines := linesOfRange(rcvr.m, buffer, body, false); len(lines) > 0 && !hasMatches(lines) {
+			shown = rcvr.addResult(IN_BLOCK, lines)
+		}
+	} else if lines := linesOfRange(rcvr.m, buffer, body, true); len(lines) > 0 {
+		shown = rcvr.addResult(IN_BLOCK, lines)
+	}
+
+	if shown {
+		info.show(body.MaxLine)
 	} else {
-		ff, err := fn.Open()
+		info.hide(buffer, body.MinOffs, body.MinLine, body.MaxLine)
+	}
+	return nil
+}
+
+// Searcher searches the blocks matched by a Matcher.
+type Searcher struct {
+	Matcher Matcher
+	// If set, final blocks without any match are searched instead.
+	Invert bool
+	// If set, only matches inside the levels selected by the Query are
+	// searched. The Matcher is expected to match any of the Query.All.
+	Query *Query
+	// If set, only matches inside levels whose headers are matched by the
+	// Matchers, from the outermost to the innermost, are searched.
+	Within []Matcher
+}
+
+func (s *Searcher) parse(p sparser.Parser, src []byte) ([]*Result, error) {
+	rcvr := NewReceiver(s.Matcher)
+	rcvr.Invert = s.Invert
+	rcvr.Query = s.Query
+	rcvr.Within = s.Within
+	if err := p.Parse(bytes.NewReader(src), rcvr); err != nil {
+		return nil, err
+	}
+	return rcvr.Results, nil
+}
+
+// Search parses the source read from in with the parser registered for ext,
+// and returns the matched blocks. If there is no parser for ext, or it fails,
+// the indent parser is used instead. ext doesn't start with '.'
+func (s *Searcher) Search(in io.Reader, ext string) ([]*Result, error) {
+	src, err := ioutil.ReadAll(in)
+	if err != nil {
+		return nil, err
+	}
+
+	if p, err := sparser.New(ext); err == nil {
+		if results, err := s.parse(p, src); err == nil {
+			return results, nil
+		}
+	}
+	return s.parse(indent.Parser{}, src)
+}
+
+// SearchFile searches file fn, or stdin if fn is empty. ext doesn't start with
+// '.'
+func (s *Searcher) SearchFile(fn villa.Path, ext string) ([]*Result, error) {
+	var in io.Reader = os.Stdin
+	if fn != "" {
+		f, err := fn.Open()
 		if err != nil {
-			log.Fatalf("Open file %v failed: %v", fn, err)
+			return nil, err
 		}
-		defer ff.Close()
-		f = ff
+		defer f.Close()
+		in = f
 	}
 
-	receiver := Receiver{
-		fn: fn,
-		re: re,
-		infos: []LevelInfo{
-			LevelInfo{
-				headerPrinted: true,
-			},
-		},
+	results, err := s.Search(in, ext)
+	if err != nil {
+		return nil, villa.NestErrorf(err, "parse %v", fn)
 	}
+	return results, nil
+}
 
-	if err := p.Parse(f, &receiver); err != nil {
-		if !isIndent && fn != "" {
-			// Try use indent parser
-			p = indent.Parser{}
-			iReceiver := Receiver{
-				fn: fn,
-				re: re,
-				infos: []LevelInfo{
-					LevelInfo{
-						headerPrinted: true,
-					},
-				},
-				maxPrintedLine: receiver.maxPrintedLine,
-				fnPrinted: receiver.fnPrinted,
-			}
+// Search returns the blocks matched by m in the source read from in. See
+// Searcher.Search.
+func Search(in io.Reader, ext string, m Matcher) ([]*Result, error) {
+	return (&Searcher{Matcher: m}).Search(in, ext)
+}
 
-			ff, err := fn.Open()
-			if err != nil {
-				log.Fatalf("Open file %v failed: %v", fn, err)
-			}
-			defer ff.Close()
-			f = ff
+// SearchFile returns the blocks matched by m in file fn, or stdin if fn is
+// empty. See Searcher.SearchFile.
+func SearchFile(fn villa.Path, ext string, m Matcher) ([]*Result, error) {
+	return (&Searcher{Matcher: m}).SearchFile(fn, ext)
+}
 
-			if err := p.Parse(f, &iReceiver); err == nil {
-				return
-			}
-		}
-		log.Fatalf("Parse %v failed: %v", fn, err)
+// Grep searches m in file fn, or stdin if fn is empty, and prints the results
+// to out. Returns whether any match is found. ext doesn't start with '.'
+func Grep(m Matcher, fn villa.Path, ext string, out Output) (found bool, err error) {
+	results, err := SearchFile(fn, ext, m)
+	if err != nil {
+		return false, err
 	}
+	Print(out, fn, m, results)
+	return len(results) > 0, nil
 }
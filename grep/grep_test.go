@@ -1,8 +1,440 @@
 package grep
 
 import (
+	"fmt"
+	"regexp"
+	"strings"
 	"testing"
+
+	"github.com/daviddengcn/go-assert"
+	"github.com/daviddengcn/go-colortext"
+	"github.com/daviddengcn/sgrep/parser"
 )
 
 func Test(t *testing.T) {
 }
+
+// textOutput is an Output recording color changes as text marks.
+type textOutput struct {
+	text string
+}
+
+func (o *textOutput) Write(p []byte) (int, error) {
+	o.text += string(p)
+	return len(p), nil
+}
+
+func (o *textOutput) ChangeColor(fg ct.Color, fgBright bool) {
+	o.text += fmt.Sprintf("<%d,%v>", fg, fgBright)
+}
+
+func (o *textOutput) ResetColor() {
+	o.text += "</>"
+}
+
+func TestBuffer(t *testing.T) {
+	var b Buffer
+	fmt.Fprint(&b, "hello ")
+	b.ChangeColor(ct.Green, true)
+	fmt.Fprint(&b, "world")
+	b.ResetColor()
+	b.ChangeColor(ct.Red, false)
+	b.ResetColor()
+	fmt.Fprint(&b, "!")
+
+	out := &textOutput{}
+	assert.NoError(t, b.Flush(out))
+	assert.Equals(t, "text", out.text, "hello <3,true>world</><2,false></>!")
+
+	out = &textOutput{}
+	assert.NoError(t, b.Flush(out))
+	assert.Equals(t, "text after flush", out.text, "")
+}
+
+func TestSearch(t *testing.T) {
+	src := `{
+	"hello": "world",
+	"numbers": [
+		1,
+		{ "go": "world"
+		}
+	]
+}`
+	results, err := Search(strings.NewReader(src), "json", regexp.MustCompile("wor"))
+	assert.NoError(t, err)
+	assert.Equals(t, "len(results)", len(results), 2)
+
+	r := results[0]
+	assert.Equals(t, "In", r.In, IN_BLOCK)
+	assert.Equals(t, "len(Levels)", len(r.Levels), 1)
+	assert.Equals(t, "len(Lines)", len(r.Lines), 1)
+	assert.Equals(t, "Line", r.Lines[0].Line, 2)
+	assert.Equals(t, "Offs", r.Lines[0].Offs, 2)
+	assert.Equals(t, "Text", string(r.Lines[0].Text), `	"hello": "world",`)
+	assert.StringEquals(t, "Matches", r.Lines[0].Matches, [][]int{{11, 14}})
+
+	r = results[1]
+	assert.Equals(t, "len(Levels)", len(r.Levels), 3)
+	assert.Equals(t, "Levels[0]", r.Levels[0], results[0].Levels[0])
+	assert.Equals(t, "Levels[1].HeaderLines[0].Text", string(r.Levels[1].HeaderLines[0].Text), `	"numbers": [`)
+	assert.Equals(t, "Levels[1].FooterLines[0].Text", string(r.Levels[1].FooterLines[0].Text), `	]`)
+	assert.Equals(t, "Levels[2].Footer.MinLine", r.Levels[2].Footer.MinLine, 6)
+	assert.Equals(t, "Lines[0].Line", r.Lines[0].Line, 5)
+
+	// falls back to the indent parser
+	results, err = Search(strings.NewReader("a:\n  b: world\n"), "unknown", regexp.MustCompile("wor"))
+	assert.NoError(t, err)
+	assert.Equals(t, "len(results)", len(results), 1)
+	assert.Equals(t, "In", results[0].In, IN_HEADER)
+	assert.Equals(t, "len(Levels)", len(results[0].Levels), 2)
+}
+
+func TestPrint(t *testing.T) {
+	src := `{
+	"a": {
+		"b": "x",
+		"c": "y"
+	},
+	"d": "x"
+}`
+	re := regexp.MustCompile("x")
+	results, err := Search(strings.NewReader(src), "json", re)
+	assert.NoError(t, err)
+
+	out := &textOutput{}
+	Print(out, "a.json", re, results)
+	assert.TextEquals(t, "output", out.text, `a.json
+      {
+      	"a": {
+   3: 		"b": "<3,true>x</>",
+      	},
+   6: 	"d": "<3,true>x</>"
+      }
+`)
+}
+
+func TestPrintJSON(t *testing.T) {
+	src := `{
+	"a": {
+		"b": "xyx"
+	}
+}`
+	re := regexp.MustCompile("x")
+	results, err := Search(strings.NewReader(src), "json", re)
+	assert.NoError(t, err)
+
+	var b Buffer
+	assert.NoError(t, PrintJSON(&b, "a.json", results))
+	out := &textOutput{}
+	assert.NoError(t, b.Flush(out))
+	assert.TextEquals(t, "output", out.text,
+		`{"file":"a.json","line":3,"column":9,"start":18,"end":19,"text":"x","line_text":"\t\t\"b\": \"xyx\"","headers":[{"line":1,"text":"{","kind":"object"},{"line":2,"text":"\"a\": {","kind":"object","name":"a"}]}
+{"file":"a.json","line":3,"column":11,"start":20,"end":21,"text":"x","line_text":"\t\t\"b\": \"xyx\"","headers":[{"line":1,"text":"{","kind":"object"},{"line":2,"text":"\"a\": {","kind":"object","name":"a"}]}
+`)
+
+	// the standard input
+	results, err = Search(strings.NewReader("x"), "", re)
+	assert.NoError(t, err)
+	b = Buffer{}
+	assert.NoError(t, PrintJSON(&b, "", results))
+	out = &textOutput{}
+	assert.NoError(t, b.Flush(out))
+	assert.TextEquals(t, "output", out.text,
+		`{"file":"(standard input)","line":1,"column":1,"start":0,"end":1,"text":"x","line_text":"x","headers":[]}
+`)
+
+	// HTML characters are not escaped
+	results, err = Search(strings.NewReader("<a>\n  <b>x</b>\n</a>\n"), "xml", re)
+	assert.NoError(t, err)
+	b = Buffer{}
+	assert.NoError(t, PrintJSON(&b, "a.xml", results))
+	out = &textOutput{}
+	assert.NoError(t, b.Flush(out))
+	assert.TextEquals(t, "output", out.text,
+		`{"file":"a.xml","line":2,"column":6,"start":9,"end":10,"text":"x","line_text":"  <b>x</b>","headers":[{"line":1,"text":"<a>","kind":"element","name":"a"},{"line":2,"text":"<b>","kind":"element","name":"b"}]}
+`)
+}
+
+func TestSearchInvert(t *testing.T) {
+	s := &Searcher{
+		Matcher: regexp.MustCompile("x"),
+		Invert:  true,
+	}
+	results, err := s.Search(strings.NewReader(`{"a": "x", "b": "y"}`), "json")
+	assert.NoError(t, err)
+	assert.Equals(t, "len(results)", len(results), 2)
+	assert.Equals(t, "results[0]", string(results[0].Lines[0].Text), `{"a": "x", "b": "y"}`)
+	assert.Equals(t, "len(Levels)", len(results[0].Levels), 1)
+
+	// leaf levels of the indent parser are taken as final blocks
+	results, err = s.Search(strings.NewReader("a:\n  b: x\n  c: y\nd\n"), "")
+	assert.NoError(t, err)
+	assert.Equals(t, "len(results)", len(results), 2)
+	assert.Equals(t, "results[0]", string(results[0].Lines[0].Text), "  c: y")
+	assert.Equals(t, "len(Levels)", len(results[0].Levels), 1)
+	assert.Equals(t, "results[1]", string(results[1].Lines[0].Text), "d")
+	assert.Equals(t, "len(Levels)", len(results[1].Levels), 0)
+
+	// a selected line which is also the header of its level
+	src := "{\n\t\"b\": { \"y\": 1,\n\t\t\"c\": \"x\"\n\t}\n}\n"
+	results, err = s.Search(strings.NewReader(src), "json")
+	assert.NoError(t, err)
+	out := &textOutput{}
+	Print(out, "", nil, results)
+	assert.TextEquals(t, "out", out.text, `      {
+   2: 	"b": { "y": 1,
+      	}
+      }
+`)
+}
+
+func TestMultiMatcher(t *testing.T) {
+	mm := MultiMatcher{
+		regexp.MustCompile("ab"),
+		regexp.MustCompile("abc|d"),
+		regexp.MustCompile("cd"),
+	}
+	assert.StringEquals(t, "locs", mm.FindAllIndex([]byte("abcd ab xcd"), -1),
+		[][]int{{0, 3, 1}, {3, 4, 1}, {5, 7, 0}, {9, 11, 2}})
+	assert.StringEquals(t, "n = 2", mm.FindAllIndex([]byte("abcd ab xcd"), 2),
+		[][]int{{0, 3, 1}, {3, 4, 1}})
+	assert.StringEquals(t, "no match", MultiMatcher{}.FindAllIndex([]byte("abc"), -1), [][]int(nil))
+
+	out := &textOutput{}
+	markAndPrint(out, 1, mm, []byte("ab cd"), false)
+	assert.Equals(t, "output", out.text, "   1: <3,true>ab</> <4,true>cd</>\n")
+}
+
+func TestSearchQuery(t *testing.T) {
+	src := `{
+	"a": {
+		"x": "Lock",
+		"y": "Unlock"
+	},
+	"b": {
+		"x": "Lock",
+		"z": "work"
+	}
+}`
+	lock, unlock := regexp.MustCompile(`\bLock`), regexp.MustCompile(`\bUnlock`)
+	s := &Searcher{
+		Matcher: MultiMatcher{lock, unlock},
+		Query: &Query{
+			All: []Matcher{lock, unlock},
+		},
+	}
+	results, err := s.Search(strings.NewReader(src), "json")
+	assert.NoError(t, err)
+	assert.Equals(t, "len(results)", len(results), 2)
+	assert.Equals(t, "results[0].Line", results[0].Lines[0].Line, 3)
+	assert.Equals(t, "results[1].Line", results[1].Lines[0].Line, 4)
+
+	s = &Searcher{
+		Matcher: lock,
+		Query: &Query{
+			All:  []Matcher{lock},
+			None: []Matcher{unlock},
+		},
+	}
+	results, err = s.Search(strings.NewReader(src), "json")
+	assert.NoError(t, err)
+	assert.Equals(t, "len(results)", len(results), 1)
+	assert.Equals(t, "results[0].Line", results[0].Lines[0].Line, 7)
+	assert.Equals(t, "len(Levels)", len(results[0].Levels), 2)
+	assert.Equals(t, "Levels[1]", results[0].Levels[1].HeaderText(), `"b": {`)
+
+	// Unlock in F rules out its if block, which is too small to contain it.
+	src = `package p
+
+func F(x bool) {
+	if x {
+		mu.Lock()
+	}
+	mu.Unlock()
+}
+
+func G() {
+	mu.Lock()
+}
+`
+	results, err = s.Search(strings.NewReader(src), "go")
+	assert.NoError(t, err)
+	assert.Equals(t, "len(results)", len(results), 1)
+	assert.Equals(t, "results[0].Line", results[0].Lines[0].Line, 11)
+	assert.Equals(t, "Levels[1]", results[0].Levels[1].HeaderText(), `func G() {`)
+
+	// Unlock in a sibling block rules out the if block too, but not G in the
+	// same package.
+	src = `package p
+
+func (s *Server) F(x bool) {
+	if x {
+		s.mu.Lock()
+	}
+	if !x {
+		s.mu.Unlock()
+	}
+}
+
+func G() {
+	mu.Lock()
+}
+`
+	results, err = s.Search(strings.NewReader(src), "go")
+	assert.NoError(t, err)
+	assert.Equals(t, "len(results)", len(results), 1)
+	assert.Equals(t, "Levels[1]", results[0].Levels[1].HeaderText(), `func G() {`)
+}
+
+func TestSearchWithin(t *testing.T) {
+	src := `{
+	"a": {
+		"b": { "x": 1 },
+		"x": 2
+	},
+	"b": { "x": 3 }
+}`
+	s := &Searcher{
+		Matcher: regexp.MustCompile(`"x"`),
+		Within:  []Matcher{regexp.MustCompile(`"a"`), regexp.MustCompile(`"b"`)},
+	}
+	results, err := s.Search(strings.NewReader(src), "json")
+	assert.NoError(t, err)
+	assert.Equals(t, "len(results)", len(results), 1)
+	assert.Equals(t, "Line", results[0].Lines[0].Line, 3)
+}
+
+func TestPrintOmitted(t *testing.T) {
+	src := `{
+	"a": {
+		"b": 1,
+		"c": {
+			"d": 2
+		},
+		"e": "x",
+		"f": 3
+	},
+	"g": 4,
+	"h": "x"
+}`
+	re := regexp.MustCompile("x")
+	results, err := Search(strings.NewReader(src), "json", re)
+	assert.NoError(t, err)
+
+	out := &textOutput{}
+	(&Printer{ShowOmitted: true}).Print(out, "", nil, results)
+	assert.TextEquals(t, "output", out.text, `      {
+      	"a": {
+      		... 4 lines / 2 blocks omitted ...
+   7: 		"e": "x",
+      		... 1 line / 1 block omitted ...
+      	},
+      	... 1 line / 1 block omitted ...
+  11: 	"h": "x"
+      }
+`)
+}
+
+func TestPrintBlock(t *testing.T) {
+	src := `{
+	"a": {
+		"b": {
+			"c": "x",
+			"d": 1
+		},
+		"e": 2
+	},
+	"f": 3
+}`
+	re := regexp.MustCompile("x")
+	results, err := Search(strings.NewReader(src), "json", re)
+	assert.NoError(t, err)
+
+	out := &textOutput{}
+	(&Printer{Block: 1}).Print(out, "", nil, results)
+	assert.TextEquals(t, "output", out.text, `      {
+      	"a": {
+      		"b": {
+   4: 			"c": "x",
+      			"d": 1
+      		},
+      	},
+      }
+`)
+
+	out = &textOutput{}
+	(&Printer{Block: 2}).Print(out, "", nil, results)
+	assert.TextEquals(t, "output", out.text, `      {
+      	"a": {
+      		"b": {
+   4: 			"c": "x",
+      			"d": 1
+      		},
+      		"e": 2
+      	},
+      }
+`)
+}
+
+func TestPrintBreadcrumb(t *testing.T) {
+	src := `<project xmlns="http://maven.apache.org/POM/4.0.0">
+  <dependencies>
+    <dependency>
+      <groupId>org.mockito</groupId>
+    </dependency>
+  </dependencies>
+</project>`
+	re := regexp.MustCompile("mockito")
+	results, err := Search(strings.NewReader(src), "xml", re)
+	assert.NoError(t, err)
+
+	out := &textOutput{}
+	(&Printer{Breadcrumb: true}).Print(out, "pom.xml", re, results)
+	assert.TextEquals(t, "output", out.text,
+		"pom.xml:4: project > dependencies > dependency | <groupId>org.<3,true>mockito</></groupId>\n")
+}
+
+func TestCrumb(t *testing.T) {
+	crumb := func(header string) string {
+		return (&Level{
+			Header:      sparser.Range{MinOffs: 0, MaxOffs: len(header) - 1, MinLine: 1, MaxLine: 1},
+			HeaderLines: []Line{{Line: 1, Text: []byte(header)}},
+		}).Crumb()
+	}
+	assert.Equals(t, "xml", crumb(`<project xmlns="a">`), "project")
+	assert.Equals(t, "go method", crumb(`func (s *Server) Serve(l net.Listener) error {`), "func (s *Server) Serve")
+	assert.Equals(t, "json", crumb(`	"numbers": [`), "numbers")
+	assert.Equals(t, "python", crumb(`def f(x):`), "def f")
+	assert.Equals(t, "empty", crumb(`{`), "")
+	assert.Equals(t, "node", (&Level{Node: sparser.Node{Kind: "type", Name: "Server"}}).Crumb(), "type Server")
+
+	header := "@app.route(\"/index\")\ndef index(request):"
+	assert.Equals(t, "decorated", (&Level{
+		Header:      sparser.Range{MinOffs: 0, MaxOffs: len(header) - 1, MinLine: 1, MaxLine: 2},
+		HeaderLines: []Line{{Line: 1, Text: []byte(header[:strings.IndexByte(header, '\n')])}, {Line: 2, Text: []byte(header[strings.IndexByte(header, '\n')+1:])}},
+		Node:        sparser.Node{Kind: "def", Name: "index"},
+	}).Crumb(), "def index")
+}
+
+func TestPrintDoc(t *testing.T) {
+	src := `package p
+
+// Foo does nothing.
+//
+// Deprecated: use Bar.
+func Foo() {
+	A()
+}
+`
+	re := regexp.MustCompile("Deprecated")
+	results, err := Search(strings.NewReader(src), "go", re)
+	assert.NoError(t, err)
+
+	out := &textOutput{}
+	Print(out, "", nil, results)
+	assert.TextEquals(t, "output", out.text, `      package p
+   5: // Deprecated: use Bar.
+      func Foo() {
+      }
+`)
+}
@@ -0,0 +1,95 @@
+package grep
+
+import (
+	"encoding/json"
+	"io"
+
+	"github.com/daviddengcn/go-villa"
+)
+
+// STDIN_NAME is the file name printed for the standard input.
+const STDIN_NAME = "(standard input)"
+
+// JSONHeader is an enclosing header of a JSONMatch.
+type JSONHeader struct {
+	// 1-based line number of the start of the header
+	Line int    `json:"line"`
+	Text string `json:"text"`
+	// The node of the level, if reported by the parser
+	Kind string `json:"kind,omitempty"`
+	Name string `json:"name,omitempty"`
+}
+
+// JSONMatch is the object printed by PrintJSON for each match.
+type JSONMatch struct {
+	File string `json:"file"`
+	// 1-based
+	Line int `json:"line"`
+	// 1-based, in bytes
+	Column int `json:"column"`
+	// [Start, End) byte offsets of the match in the file
+	Start int `json:"start"`
+	End   int `json:"end"`
+	// The matched text
+	Text string `json:"text"`
+	// The line containing the match
+	LineText string `json:"line_text"`
+	// Headers of the enclosing levels, from the outermost
+	Headers []JSONHeader `json:"headers"`
+}
+
+// JSONMatches converts the results of file fn into JSONMatches. An empty fn
+// is the standard input.
+func JSONMatches(fn villa.Path, results []*Result) []JSONMatch {
+	file := string(fn)
+	if fn == "" {
+		file = STDIN_NAME
+	}
+	var matches []JSONMatch
+	for _, r := range results {
+		levels := r.Levels
+		if r.In != IN_BLOCK {
+			// the level of a header or footer doesn't enclose it
+			levels = levels[:len(levels)-1]
+		}
+		headers := make([]JSONHeader, 0, len(levels))
+		for _, level := range levels {
+			headers = append(headers, JSONHeader{
+				Line: level.Header.MinLine,
+				Text: level.HeaderText(),
+				Kind: level.Node.Kind,
+				Name: level.Node.Name,
+			})
+		}
+
+		for _, line := range r.Lines {
+			for _, loc := range line.Matches {
+				matches = append(matches, JSONMatch{
+					File:     file,
+					Line:     line.Line,
+					Column:   loc[0] + 1,
+					Start:    line.Offs + loc[0],
+					End:      line.Offs + loc[1],
+					Text:     string(line.Text[loc[0]:loc[1]]),
+					LineText: string(line.Text),
+					Headers:  headers,
+				})
+			}
+		}
+	}
+	return matches
+}
+
+// PrintJSON prints the results of file fn to w in the JSON Lines format, i.e.
+// a JSONMatch object per line for each match.
+func PrintJSON(w io.Writer, fn villa.Path, results []*Result) error {
+	enc := json.NewEncoder(w)
+	// texts like <groupId> are kept as is for readers other than browsers
+	enc.SetEscapeHTML(false)
+	for _, m := range JSONMatches(fn, results) {
+		if err := enc.Encode(m); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,52 @@
+package grep
+
+import (
+	"sort"
+)
+
+// MultiMatcher is a Matcher matching any of several Matchers. Each location
+// returned by FindAllIndex has a third element, the index of the matched
+// Matcher, which is used for choosing the highlight color.
+type MultiMatcher []Matcher
+
+// FindAllIndex returns the successive non-overlapping matches of all
+// Matchers. The leftmost match is preferred, and the longer one if two start
+// at the same position.
+func (mm MultiMatcher) FindAllIndex(b []byte, n int) [][]int {
+	var all [][]int
+	for i, m := range mm {
+		for _, loc := range m.FindAllIndex(b, -1) {
+			all = append(all, []int{loc[0], loc[1], i})
+		}
+	}
+	sort.Sort(locsByStart(all))
+
+	var locs [][]int
+	end := 0
+	for _, loc := range all {
+		if n >= 0 && len(locs) >= n {
+			break
+		}
+		if loc[0] < end || len(locs) > 0 && loc[0] == end && loc[0] == loc[1] {
+			// overlapping, or an empty match right after the previous one
+			continue
+		}
+		locs = append(locs, loc)
+		end = loc[1]
+	}
+	return locs
+}
+
+type locsByStart [][]int
+
+func (l locsByStart) Len() int      { return len(l) }
+func (l locsByStart) Swap(i, j int) { l[i], l[j] = l[j], l[i] }
+func (l locsByStart) Less(i, j int) bool {
+	if l[i][0] != l[j][0] {
+		return l[i][0] < l[j][0]
+	}
+	if l[i][1] != l[j][1] {
+		return l[i][1] > l[j][1]
+	}
+	return l[i][2] < l[j][2]
+}
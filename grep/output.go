@@ -0,0 +1,96 @@
+package grep
+
+import (
+	"bytes"
+	"io"
+	"os"
+
+	"github.com/daviddengcn/go-colortext"
+)
+
+// Output is where the results of Grep are printed to. Besides text, it also
+// receives the changes of the text color.
+type Output interface {
+	io.Writer
+	ChangeColor(fg ct.Color, fgBright bool)
+	ResetColor()
+}
+
+type stdout struct{}
+
+func (stdout) Write(p []byte) (int, error) {
+	return os.Stdout.Write(p)
+}
+
+func (stdout) ChangeColor(fg ct.Color, fgBright bool) {
+	ct.ChangeColor(fg, fgBright, ct.None, false)
+}
+
+func (stdout) ResetColor() {
+	ct.ResetColor()
+}
+
+// Stdout is an Output printing directly to os.Stdout.
+var Stdout Output = stdout{}
+
+type colorChange struct {
+	// offset in the text where the color changes
+	offs     int
+	reset    bool
+	fg       ct.Color
+	fgBright bool
+}
+
+// Buffer is an Output keeping everything in memory. It's used for collecting
+// the results of a file searched concurrently so that they could be printed
+// later without being interleaved with others.
+type Buffer struct {
+	text    bytes.Buffer
+	changes []colorChange
+}
+
+func (b *Buffer) Write(p []byte) (int, error) {
+	return b.text.Write(p)
+}
+
+func (b *Buffer) ChangeColor(fg ct.Color, fgBright bool) {
+	b.changes = append(b.changes, colorChange{
+		offs:     b.text.Len(),
+		fg:       fg,
+		fgBright: fgBright,
+	})
+}
+
+func (b *Buffer) ResetColor() {
+	b.changes = append(b.changes, colorChange{
+		offs:  b.text.Len(),
+		reset: true,
+	})
+}
+
+// Flush prints the buffered text, with colors, to out and clears the buffer.
+func (b *Buffer) Flush(out Output) error {
+	text := b.text.Bytes()
+	p := 0
+	for _, c := range b.changes {
+		if c.offs > p {
+			if _, err := out.Write(text[p:c.offs]); err != nil {
+				return err
+			}
+			p = c.offs
+		}
+		if c.reset {
+			out.ResetColor()
+		} else {
+			out.ChangeColor(c.fg, c.fgBright)
+		}
+	}
+	if p < len(text) {
+		if _, err := out.Write(text[p:]); err != nil {
+			return err
+		}
+	}
+	b.text.Reset()
+	b.changes = b.changes[:0]
+	return nil
+}
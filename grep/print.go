@@ -0,0 +1,262 @@
+package grep
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+
+	"github.com/daviddengcn/go-colortext"
+	"github.com/daviddengcn/go-villa"
+)
+
+// Highlight colors of matches, indexed by the third element of the location
+// returned by a Matcher, e.g. a MultiMatcher.
+var matchColors = []ct.Color{ct.Green, ct.Red, ct.Yellow, ct.Cyan, ct.Magenta, ct.Blue}
+
+func matchColor(loc []int) ct.Color {
+	if len(loc) < 3 {
+		return matchColors[0]
+	}
+	return matchColors[loc[2]%len(matchColors)]
+}
+
+// markAndPrint prints a line with matches of m highlighted. The line number is
+// printed if the line is selected or has any match. m could be nil.
+func markAndPrint(out Output, ln int, m Matcher, line []byte, selected bool) {
+	var locs [][]int
+	if m != nil {
+		locs = m.FindAllIndex(line, -1)
+	}
+	if selected || len(locs) > 0 {
+		fmt.Fprintf(out, "%4d: ", ln)
+	} else {
+		fmt.Fprint(out, "      ")
+	}
+	printMarked(out, line, locs, 0)
+}
+
+// printMarked prints line[from:] with the matches at locs highlighted, and a
+// new line.
+func printMarked(out Output, line []byte, locs [][]int, from int) {
+	p := from
+	for _, loc := range locs {
+		if loc[1] <= p {
+			continue
+		}
+		if loc[0] > p {
+			out.Write(line[p:loc[0]])
+			p = loc[0]
+		}
+		out.ChangeColor(matchColor(loc), true)
+		out.Write(line[p:loc[1]])
+		out.ResetColor()
+		p = loc[1]
+	}
+	if p < len(line) {
+		out.Write(line[p:])
+	}
+	fmt.Fprintln(out)
+}
+
+// plural returns the count n of noun, e.g. "1 line" and "2 lines".
+func plural(n int, noun string) string {
+	if n == 1 {
+		return fmt.Sprintf("%d %s", n, noun)
+	}
+	return fmt.Sprintf("%d %ss", n, noun)
+}
+
+type printer struct {
+	*Printer
+	out Output
+	m   Matcher
+	// 1-based line numbers printed, which could be out of order, e.g. for
+	// grouped methods
+	printed map[int]bool
+	// levels whose headers have been printed but footers not yet.
+	levels []*Level
+	// line numbers of the results
+	selected map[int]bool
+}
+
+// showLines prints lines not printed yet. A line is taken as selected if
+// selected is set, or it is a line of any result, e.g. a header line which is
+// also a final block with -v.
+func (p *printer) showLines(lines []Line, selected bool) {
+	for _, line := range lines {
+		if !p.printed[line.Line] {
+			markAndPrint(p.out, line.Line, p.m, line.Text, selected || p.selected[line.Line])
+			p.printed[line.Line] = true
+		}
+	}
+}
+
+// showOmitted prints the summary of omitted blocks if ShowOmitted is set.
+func (p *printer) showOmitted(o *Omitted) {
+	if !p.ShowOmitted || o == nil {
+		return
+	}
+	fmt.Fprintf(p.out, "      %s... %s / %s omitted ...\n", o.Indent,
+		plural(o.Lines, "line"), plural(o.Blocks, "block"))
+}
+
+// closeLevels prints the footers of the printed levels beyond the first n.
+func (p *printer) closeLevels(n int) {
+	for len(p.levels) > n {
+		level := p.levels[len(p.levels)-1]
+		p.showOmitted(level.OmittedAfter)
+		p.showLines(level.FooterLines, false)
+		p.levels = p.levels[:len(p.levels)-1]
+	}
+}
+
+// showBlock prints all the lines of a level, unless they have been printed.
+func (p *printer) showBlock(block *Level) {
+	if p.printed[block.Extent.MaxLine] {
+		return
+	}
+	if !p.printed[block.Extent.MinLine] {
+		p.showOmitted(block.OmittedBefore)
+	}
+	p.showLines(block.Lines(p.m), false)
+}
+
+func (p *printer) show(r *Result) {
+	levels := r.Levels
+	var block *Level
+	if p.Block > 0 && len(levels) > 0 {
+		i := len(levels) - p.Block
+		if i < 0 {
+			i = 0
+		}
+		block, levels = levels[i], levels[:i]
+	}
+
+	n := 0
+	for n < len(p.levels) && n < len(levels) && p.levels[n] == levels[n] {
+		n++
+	}
+	p.closeLevels(n)
+	for _, level := range levels[n:] {
+		p.showOmitted(level.OmittedBefore)
+		if r.In == IN_HEADER && level == r.Levels[len(r.Levels)-1] {
+			// the lines in the doc, before the header
+			for i := range r.Lines {
+				if r.Lines[i].Line < level.Header.MinLine {
+					p.showLines(r.Lines[i:i+1], true)
+				}
+			}
+		}
+		if len(level.HeaderLines) == 0 && level.Node.Name != "" {
+			// a level without header, e.g. a group of Go methods
+			fmt.Fprintf(p.out, "      %s %s\n", level.Node.Kind, level.Node.Name)
+		}
+		p.showLines(level.HeaderLines, false)
+		p.levels = append(p.levels, level)
+	}
+	if block != nil {
+		p.showBlock(block)
+		return
+	}
+	if r.In == IN_FOOTER {
+		// printed after the omitted blocks when the level is closed
+		return
+	}
+	p.showOmitted(r.OmittedBefore)
+	p.showLines(r.Lines, true)
+}
+
+// Printer prints results as text.
+type Printer struct {
+	// Whether to print a summary of the blocks omitted between printed ones.
+	ShowOmitted bool
+	// If positive, the whole enclosing level of each result is printed, the
+	// innermost one for 1, its parent for 2, and so on.
+	Block int
+	// Whether to print each line of the results in one line, prefixed with
+	// the crumbs of its enclosing levels, e.g.
+	//   pom.xml:13: project > dependencies > dependency | <artifactId>...
+	Breadcrumb bool
+}
+
+// crumbs returns the crumbs of the enclosing levels of a line of r, joined
+// with " > ". Levels whose headers start at the line, or the level of a footer
+// result, are excluded since they are shown in the line. So are packages,
+// which enclose whole files.
+func crumbs(r *Result, line int) string {
+	levels := r.Levels
+	if r.In == IN_FOOTER && len(levels) > 0 {
+		levels = levels[:len(levels)-1]
+	}
+	for len(levels) > 0 && levels[len(levels)-1].Header.MinLine == line {
+		levels = levels[:len(levels)-1]
+	}
+	var names []string
+	for _, level := range levels {
+		if level.Node.Kind == "package" {
+			continue
+		}
+		if crumb := level.Crumb(); crumb != "" {
+			names = append(names, crumb)
+		}
+	}
+	return strings.Join(names, " > ")
+}
+
+// printBreadcrumbs prints each line of results in one line with the crumbs.
+func printBreadcrumbs(out Output, fn villa.Path, m Matcher, results []*Result) {
+	printed := make(map[int]bool)
+	for _, r := range results {
+		for _, line := range r.Lines {
+			if printed[line.Line] {
+				continue
+			}
+			printed[line.Line] = true
+			if fn != "" {
+				fmt.Fprintf(out, "%v:", fn)
+			}
+			fmt.Fprintf(out, "%d: %s | ", line.Line, crumbs(r, line.Line))
+			var locs [][]int
+			if m != nil {
+				locs = m.FindAllIndex(line.Text, -1)
+			}
+			printMarked(out, line.Text, locs, len(line.Text)-len(bytes.TrimLeft(line.Text, " \t")))
+		}
+	}
+}
+
+// Print prints the results of file fn to out with the headers and footers of
+// enclosing levels. Matches of m are highlighted if m is not nil.
+func (pr *Printer) Print(out Output, fn villa.Path, m Matcher, results []*Result) {
+	if len(results) == 0 {
+		return
+	}
+	if pr.Breadcrumb {
+		printBreadcrumbs(out, fn, m, results)
+		return
+	}
+	if fn != "" {
+		fmt.Fprintln(out, fn)
+	}
+	p := printer{
+		Printer:  pr,
+		out:      out,
+		m:        m,
+		printed:  make(map[int]bool),
+		selected: make(map[int]bool),
+	}
+	for _, r := range results {
+		for _, line := range r.Lines {
+			p.selected[line.Line] = true
+		}
+	}
+	for _, r := range results {
+		p.show(r)
+	}
+	p.closeLevels(0)
+}
+
+// Print prints the results with the default Printer.
+func Print(out Output, fn villa.Path, m Matcher, results []*Result) {
+	(&Printer{}).Print(out, fn, m, results)
+}
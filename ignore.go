@@ -0,0 +1,185 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// Names of the files listing ignore patterns, in the order of increasing
+// precedence. Both use the .gitignore syntax.
+var ignoreFileNames = []string{".gitignore", ".sgrepignore"}
+
+// ignoreRule is a single pattern line of an ignore file.
+type ignoreRule struct {
+	// pattern split by '/'. Unanchored patterns start with "**".
+	segs    []string
+	negate  bool
+	dirOnly bool
+}
+
+func matchSegs(pat, segs []string) bool {
+	for len(pat) > 0 {
+		if pat[0] == "**" {
+			pat = pat[1:]
+			if len(pat) == 0 {
+				// a trailing "/**" matches everything inside
+				return len(segs) > 0
+			}
+			for i := 0; i < len(segs); i++ {
+				if matchSegs(pat, segs[i:]) {
+					return true
+				}
+			}
+			return false
+		}
+		if len(segs) == 0 {
+			return false
+		}
+		if ok, _ := path.Match(pat[0], segs[0]); !ok {
+			return false
+		}
+		pat, segs = pat[1:], segs[1:]
+	}
+	return len(segs) == 0
+}
+
+func (r *ignoreRule) match(segs []string, isDir bool) bool {
+	if r.dirOnly && !isDir {
+		return false
+	}
+	return matchSegs(r.segs, segs)
+}
+
+// trimTrailingSpaces removes trailing spaces unless they are escaped with a
+// backslash.
+func trimTrailingSpaces(line string) string {
+	for strings.HasSuffix(line, " ") && !strings.HasSuffix(line, `\ `) {
+		line = line[:len(line)-1]
+	}
+	return line
+}
+
+func parseIgnoreRules(content []byte) (rules []ignoreRule) {
+	for _, line := range strings.Split(string(content), "\n") {
+		line = trimTrailingSpaces(strings.TrimSuffix(line, "\r"))
+		if line == "" || line[0] == '#' {
+			continue
+		}
+		var rule ignoreRule
+		if line[0] == '!' {
+			rule.negate = true
+			line = line[1:]
+		} else if strings.HasPrefix(line, `\!`) || strings.HasPrefix(line, `\#`) {
+			line = line[1:]
+		}
+		if strings.HasSuffix(line, "/") {
+			rule.dirOnly = true
+			line = strings.TrimRight(line, "/")
+		}
+		if line == "" {
+			continue
+		}
+		// A pattern with a slash at the beginning or middle is relative to the
+		// folder of the ignore file, otherwise it matches at any level below.
+		anchored := strings.ContainsRune(line, '/')
+		rule.segs = strings.Split(strings.TrimPrefix(line, "/"), "/")
+		if !anchored {
+			rule.segs = append([]string{"**"}, rule.segs...)
+		}
+		rules = append(rules, rule)
+	}
+	return rules
+}
+
+// ignoreFile is the rules loaded from the ignore files in one folder.
+type ignoreFile struct {
+	rules []ignoreRule
+	// For a folder above the walk root, the segments of the walk root relative
+	// to this folder.
+	prefix []string
+	// Number of path segments of this folder relative to the walk root.
+	depth int
+}
+
+// ignoreList is the stack of ignoreFiles from the outermost folder to the
+// current one.
+type ignoreList []*ignoreFile
+
+// load appends the rules in the ignore files of dir, if any.
+func (l ignoreList) load(dir string, prefix []string, depth int) ignoreList {
+	var rules []ignoreRule
+	for _, name := range ignoreFileNames {
+		content, err := ioutil.ReadFile(filepath.Join(dir, name))
+		if err != nil {
+			continue
+		}
+		rules = append(rules, parseIgnoreRules(content)...)
+	}
+	if len(rules) == 0 {
+		return l
+	}
+	// Use a full slice expression so that sibling folders never share the
+	// appended element.
+	return append(l[:len(l):len(l)], &ignoreFile{
+		rules:  rules,
+		prefix: prefix,
+		depth:  depth,
+	})
+}
+
+// ignored returns true if the path, in segments relative to the walk root, is
+// ignored. Rules in deeper folders take precedence, and the last matching rule
+// in a folder wins, as git does.
+func (l ignoreList) ignored(segs []string, isDir bool) bool {
+	for i := len(l) - 1; i >= 0; i-- {
+		f := l[i]
+		rel := segs[f.depth:]
+		if len(f.prefix) > 0 {
+			rel = append(append([]string(nil), f.prefix...), rel...)
+		}
+		for j := len(f.rules) - 1; j >= 0; j-- {
+			if f.rules[j].match(rel, isDir) {
+				return !f.rules[j].negate
+			}
+		}
+	}
+	return false
+}
+
+func exists(fn string) bool {
+	_, err := os.Stat(fn)
+	return err == nil
+}
+
+// loadParentIgnores loads the ignore files in the folders between the root of
+// the git repository containing root and the parent of root. Returns nil if
+// root is not inside a git repository.
+func loadParentIgnores(root string) ignoreList {
+	abs, err := filepath.Abs(root)
+	if err != nil {
+		return nil
+	}
+	var parents []string
+	for dir := abs; !exists(filepath.Join(dir, ".git")); {
+		parent := filepath.Dir(dir)
+		if parent == dir {
+			// not in a git repository
+			return nil
+		}
+		dir = parent
+		parents = append(parents, dir)
+	}
+
+	var l ignoreList
+	for i := len(parents) - 1; i >= 0; i-- {
+		rel, err := filepath.Rel(parents[i], abs)
+		if err != nil {
+			return nil
+		}
+		l = l.load(parents[i], strings.Split(filepath.ToSlash(rel), "/"), 0)
+	}
+	return l
+}
@@ -0,0 +1,711 @@
+package brace
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"sort"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/daviddengcn/sgrep/parser"
+)
+
+// Language describes the lexical syntax of a brace language. All of them have
+// "//" and "/* */" comments and "..." strings with backslash escapes.
+type Language struct {
+	// "/* */" comments could be nested.
+	NestedComments bool
+	// Lines starting with '#' are preprocessor directives.
+	Preprocessor bool
+	// '...' are char literals or lifetimes, as in Rust, instead of strings.
+	Lifetimes bool
+	// `...${expr}...` template literals.
+	Templates bool
+	// /.../ regular expression literals.
+	Regexps bool
+	// """...""" text blocks or raw strings.
+	TextBlocks bool
+	// R"delim(...)delim" raw strings of C++.
+	CppRawStrings bool
+	// r#"..."# raw strings of Rust.
+	RustRawStrings bool
+	// @"..." verbatim strings of C#.
+	VerbatimStrings bool
+	// The prefix of attributes or annotations, e.g. "#[" or "@", which are
+	// reported as the doc of the declaration they precede.
+	Attributes string
+	// Statements could end at line breaks without ';', as in JavaScript.
+	LineBreaks bool
+}
+
+var (
+	C          = &Language{Preprocessor: true}
+	CPP        = &Language{Preprocessor: true, CppRawStrings: true, Attributes: "[["}
+	Java       = &Language{TextBlocks: true, Attributes: "@"}
+	JavaScript = &Language{Templates: true, Regexps: true, Attributes: "@", LineBreaks: true}
+	Rust       = &Language{NestedComments: true, Lifetimes: true, RustRawStrings: true, Attributes: "#"}
+	CSharp     = &Language{Preprocessor: true, TextBlocks: true, VerbatimStrings: true, Attributes: "["}
+)
+
+// Parser parses the source of Lang.
+type Parser struct {
+	Lang *Language
+}
+
+func init() {
+	for lang, exts := range map[*Language][]string{
+		C:          {"c", "h"},
+		CPP:        {"cc", "cpp", "cxx", "c++", "hh", "hpp", "hxx", "h++"},
+		Java:       {"java"},
+		JavaScript: {"js", "jsx", "mjs", "cjs", "ts", "tsx"},
+		Rust:       {"rs"},
+		CSharp:     {"cs"},
+	} {
+		lang := lang
+		factory := func() (sparser.Parser, error) {
+			return Parser{Lang: lang}, nil
+		}
+		for _, ext := range exts {
+			sparser.Register(ext, factory)
+		}
+	}
+}
+
+func isIdentByte(b byte) bool {
+	return b == '_' || b == '$' || b >= 'a' && b <= 'z' || b >= 'A' && b <= 'Z' || b >= '0' && b <= '9' || b >= 0x80
+}
+
+type parser struct {
+	lang *Language
+	src  []byte
+	rcvr sparser.Receiver
+	// offsets of the starts of lines
+	lineStarts []int
+	pos        int
+	// start of the pending statement, or -1
+	stmt int
+	// end of the last token of the pending statement
+	stmtEnd int
+	// the last byte of code, excluding comments
+	prev byte
+	// unclosed parentheses and brackets in the current level
+	parens int
+	// parens of the outer levels
+	levels []int
+}
+
+func (p *parser) lineOf(offs int) int {
+	return sort.SearchInts(p.lineStarts, offs+1)
+}
+
+// rangeOf returns the range of src[start:end].
+func (p *parser) rangeOf(start, end int) sparser.Range {
+	return sparser.Range{
+		MinOffs: start,
+		MaxOffs: end - 1,
+		MinLine: p.lineOf(start),
+		MaxLine: p.lineOf(end - 1),
+	}
+}
+
+// lineEnd returns the offset of the end of the line at pos, excluding the
+// line break.
+func (p *parser) lineEnd(pos int) int {
+	end := bytes.IndexByte(p.src[pos:], '\n')
+	if end < 0 {
+		end = len(p.src)
+	} else {
+		end += pos
+	}
+	if end > pos && p.src[end-1] == '\r' {
+		end--
+	}
+	return end
+}
+
+// quotedEnd returns the end of the string or char quoted by quote at pos.
+// Unterminated ones end at the line break.
+func (p *parser) quotedEnd(pos int, quote byte) int {
+	for i := pos + 1; i < len(p.src); i++ {
+		switch p.src[i] {
+		case '\\':
+			i++
+		case quote:
+			return i + 1
+		case '\n':
+			return i
+		}
+	}
+	return len(p.src)
+}
+
+// delimitedEnd returns the end of the delimiter after pos, or the end of src.
+func (p *parser) delimitedEnd(pos int, delim string) int {
+	if i := bytes.Index(p.src[pos:], []byte(delim)); i >= 0 {
+		return pos + i + len(delim)
+	}
+	return len(p.src)
+}
+
+func (p *parser) blockCommentEnd(pos int) int {
+	if !p.lang.NestedComments {
+		return p.delimitedEnd(pos+2, "*/")
+	}
+	depth := 0
+	for i := pos; i+1 < len(p.src); i++ {
+		if p.src[i] == '/' && p.src[i+1] == '*' {
+			depth++
+			i++
+		} else if p.src[i] == '*' && p.src[i+1] == '/' {
+			i++
+			if depth--; depth == 0 {
+				return i + 1
+			}
+		}
+	}
+	return len(p.src)
+}
+
+// templateEnd returns the end of the template literal at pos, skipping the
+// expressions in it.
+func (p *parser) templateEnd(pos int) int {
+	for i := pos + 1; i < len(p.src); i++ {
+		switch p.src[i] {
+		case '\\':
+			i++
+		case '`':
+			return i + 1
+		case '$':
+			if i+1 < len(p.src) && p.src[i+1] == '{' {
+				i = p.exprEnd(i+2) - 1
+			}
+		}
+	}
+	return len(p.src)
+}
+
+// exprEnd returns the end of the expression in a template literal at pos,
+// including the closing '}'.
+func (p *parser) exprEnd(pos int) int {
+	depth := 0
+	prev := byte('{')
+	for i := pos; i < len(p.src); {
+		if end, _ := p.tokenEnd(i, prev); end > i {
+			i, prev = end, '"'
+			continue
+		}
+		switch p.src[i] {
+		case '{':
+			depth++
+		case '}':
+			if depth == 0 {
+				return i + 1
+			}
+			depth--
+		}
+		prev = p.src[i]
+		i++
+	}
+	return len(p.src)
+}
+
+// regexpEnd returns the end of the regular expression literal at pos, or pos
+// if it is not one.
+func (p *parser) regexpEnd(pos int) int {
+	inClass := false
+	for i := pos + 1; i < len(p.src); i++ {
+		switch p.src[i] {
+		case '\\':
+			i++
+		case '[':
+			inClass = true
+		case ']':
+			inClass = false
+		case '/':
+			if !inClass {
+				for i++; i < len(p.src) && isIdentByte(p.src[i]); i++ {
+				}
+				return i
+			}
+		case '\n':
+			return pos
+		}
+	}
+	return pos
+}
+
+// regexpAllowed returns true if a '/' after the code ending at pos, whose last
+// byte is prev, starts a regular expression rather than a division.
+func (p *parser) regexpAllowed(pos int, prev byte) bool {
+	if prev == 0 || strings.IndexByte("(,=:[!&|?{};+-*%<>~^", prev) >= 0 {
+		return true
+	}
+	if !isIdentByte(prev) {
+		return false
+	}
+	for pos > 0 && (p.src[pos-1] == ' ' || p.src[pos-1] == '\t') {
+		pos--
+	}
+	start := pos
+	for start > 0 && isIdentByte(p.src[start-1]) {
+		start--
+	}
+	switch string(p.src[start:pos]) {
+	case "return", "typeof", "case", "in", "of", "yield", "void", "delete":
+		return true
+	}
+	return false
+}
+
+// rustRawEnd returns the end of the Rust raw string at pos, or pos if it is
+// not one.
+func (p *parser) rustRawEnd(pos int) int {
+	i := pos + 1
+	for i < len(p.src) && p.src[i] == '#' {
+		i++
+	}
+	if i >= len(p.src) || p.src[i] != '"' {
+		return pos
+	}
+	return p.delimitedEnd(i+1, `"`+strings.Repeat("#", i-pos-1))
+}
+
+// cppRawEnd returns the end of the C++ raw string at pos, or pos if it is not
+// one.
+func (p *parser) cppRawEnd(pos int) int {
+	open := bytes.IndexByte(p.src[pos+2:], '(')
+	if open < 0 || open > 16 || bytes.ContainsAny(p.src[pos+2:pos+2+open], " \t\n\\)") {
+		return pos
+	}
+	return p.delimitedEnd(pos+3+open, ")"+string(p.src[pos+2:pos+2+open])+`"`)
+}
+
+// charEnd returns the end of the Rust char literal at pos, or pos+1 for a
+// lifetime.
+func (p *parser) charEnd(pos int) int {
+	if pos+1 < len(p.src) && p.src[pos+1] == '\\' {
+		return p.quotedEnd(pos, '\'')
+	}
+	_, size := utf8.DecodeRune(p.src[pos+1:])
+	if end := pos + 1 + size; end < len(p.src) && p.src[end] == '\'' {
+		return end + 1
+	}
+	return pos + 1
+}
+
+// tokenEnd returns the end of the comment, string, char or regular expression
+// literal at pos, or pos if there is none. prev is the last byte of code
+// before pos.
+func (p *parser) tokenEnd(pos int, prev byte) (end int, isComment bool) {
+	src, lang := p.src, p.lang
+	next := byte(0)
+	if pos+1 < len(src) {
+		next = src[pos+1]
+	}
+	startsIdent := pos == 0 || !isIdentByte(src[pos-1])
+	switch c := src[pos]; {
+	case c == '/' && next == '/':
+		return p.lineEnd(pos), true
+	case c == '/' && next == '*':
+		return p.blockCommentEnd(pos), true
+	case c == '/' && lang.Regexps && p.regexpAllowed(pos, prev):
+		return p.regexpEnd(pos), false
+	case c == '"' && lang.TextBlocks && bytes.HasPrefix(src[pos:], []byte(`"""`)):
+		return p.delimitedEnd(pos+3, `"""`), false
+	case c == '"':
+		return p.quotedEnd(pos, '"'), false
+	case c == '\'' && lang.Lifetimes:
+		return p.charEnd(pos), false
+	case c == '\'':
+		return p.quotedEnd(pos, '\''), false
+	case c == '`' && lang.Templates:
+		return p.templateEnd(pos), false
+	case c == '@' && lang.VerbatimStrings && (next == '"' || next == '$' && pos+2 < len(src) && src[pos+2] == '"'):
+		for i := bytes.IndexByte(src[pos:], '"') + pos + 1; i < len(src); i++ {
+			if src[i] == '"' {
+				if i+1 < len(src) && src[i+1] == '"' {
+					i++
+					continue
+				}
+				return i + 1, false
+			}
+		}
+		return len(src), false
+	case c == 'R' && lang.CppRawStrings && next == '"' && (startsIdent || strings.IndexByte("8uUL", src[pos-1]) >= 0):
+		return p.cppRawEnd(pos), false
+	case c == 'r' && lang.RustRawStrings && (next == '"' || next == '#') && (startsIdent || src[pos-1] == 'b'):
+		return p.rustRawEnd(pos), false
+	}
+	return pos, false
+}
+
+// directiveEnd returns the end of the preprocessor directive at pos, including
+// its continuation lines.
+func (p *parser) directiveEnd(pos int) int {
+	for {
+		end := p.lineEnd(pos)
+		if end == pos || p.src[end-1] != '\\' || end >= len(p.src) {
+			return end
+		}
+		pos = end + 1
+	}
+}
+
+func (p *parser) atLineStart(pos int) bool {
+	for i := pos - 1; i >= 0 && p.src[i] != '\n'; i-- {
+		if p.src[i] != ' ' && p.src[i] != '\t' {
+			return false
+		}
+	}
+	return true
+}
+
+// attributesEnd returns the end of the attributes at the start of
+// src[start:end], or start if there are none.
+func (p *parser) attributesEnd(start, end int) int {
+	attrs := start
+	for i := start; i < end; {
+		if !bytes.HasPrefix(p.src[i:end], []byte(p.lang.Attributes)) ||
+			bytes.HasPrefix(p.src[i:end], []byte("@interface")) {
+			break
+		}
+		i += len(p.lang.Attributes)
+		// the name, and the balanced brackets or parentheses after it
+		for i < end && (isIdentByte(p.src[i]) || p.src[i] == '.' || p.src[i] == '!') {
+			i++
+		}
+		for depth := 0; i < end; {
+			if tEnd, _ := p.tokenEnd(i, '('); tEnd > i {
+				i = tEnd
+				continue
+			}
+			c := p.src[i]
+			if c == '(' || c == '[' {
+				depth++
+			} else if c == ')' || c == ']' {
+				depth--
+			} else if depth == 0 {
+				break
+			}
+			i++
+		}
+		attrs = i
+		for i < end && strings.IndexByte(" \t\r\n", p.src[i]) >= 0 {
+			i++
+		}
+	}
+	return attrs
+}
+
+// code adds src[pos:end] to the pending statement.
+func (p *parser) code(end int) {
+	if p.stmt < 0 {
+		p.stmt = p.pos
+	}
+	p.stmtEnd, p.prev, p.pos = end, p.src[end-1], end
+}
+
+// flush sends the pending statement, if any, as a final block.
+func (p *parser) flush() error {
+	if p.stmt < 0 {
+		return nil
+	}
+	start := p.stmt
+	p.stmt = -1
+	return p.rcvr.FinalBlock(p.src, p.rangeOf(start, p.stmtEnd))
+}
+
+// continuedWords are the words at the end of a line which continue the
+// statement to the next one.
+var continuedWords = map[string]bool{
+	"else": true, "do": true, "extends": true, "implements": true, "new": true,
+	"in": true, "of": true, "instanceof": true, "typeof": true,
+}
+
+// endsAtLineBreak guesses whether the pending statement ends at the line break
+// at pos, i.e. neither its last token nor the next code continues it.
+func (p *parser) endsAtLineBreak(pos int) bool {
+	stmt := p.src[p.stmt:p.stmtEnd]
+	if bytes.HasSuffix(stmt, []byte("++")) || bytes.HasSuffix(stmt, []byte("--")) {
+		return true
+	}
+	if strings.IndexByte("+-*/%=&|^<>!?:,.([{~", p.prev) >= 0 {
+		return false
+	}
+	last := len(stmt)
+	for last > 0 && isIdentByte(stmt[last-1]) {
+		last--
+	}
+	if continuedWords[string(stmt[last:])] {
+		return false
+	}
+	if first, _ := words(stmt); p.prev == ')' && len(first) > 0 && (first[0] == "if" || first[0] == "for" || first[0] == "while") {
+		// a header without braces
+		return false
+	}
+	if p.lang.Attributes != "" && p.attributesEnd(p.stmt, p.stmtEnd) == p.stmtEnd {
+		// decorators before a declaration
+		return false
+	}
+	// the next code, skipping comments
+	for i := pos; i < len(p.src); {
+		if strings.IndexByte(" \t\r\n\f\v", p.src[i]) >= 0 {
+			i++
+			continue
+		}
+		if end, isComment := p.tokenEnd(i, p.prev); isComment {
+			i = end
+			continue
+		}
+		return strings.IndexByte(".?:+-*/%=&|^<>,)]([{", p.src[i]) < 0
+	}
+	return true
+}
+
+// footerEnd returns the end of the footer of a level closed by the '}' at pos,
+// which includes closing parentheses and brackets, and a ';' or ',' in the
+// same line.
+func (p *parser) footerEnd(pos int) int {
+	end := pos + 1
+	for i := end; i < len(p.src); i++ {
+		switch p.src[i] {
+		case ' ', '\t':
+			continue
+		case ')', ']':
+			if p.parens == 0 {
+				return end
+			}
+			p.parens--
+			end = i + 1
+			continue
+		case ';', ',':
+			return i + 1
+		}
+		break
+	}
+	return end
+}
+
+func (p *parser) parse() error {
+	for p.pos < len(p.src) {
+		c := p.src[p.pos]
+		switch c {
+		case ' ', '\t', '\r', '\n', '\f', '\v':
+			if c == '\n' && p.lang.LineBreaks && p.stmt >= 0 && p.parens == 0 && p.endsAtLineBreak(p.pos) {
+				if err := p.flush(); err != nil {
+					return err
+				}
+			}
+			p.pos++
+			continue
+		}
+		if c == '#' && p.lang.Preprocessor && p.stmt < 0 && p.atLineStart(p.pos) {
+			end := p.directiveEnd(p.pos)
+			if err := p.rcvr.FinalBlock(p.src, p.rangeOf(p.pos, end)); err != nil {
+				return err
+			}
+			p.pos = end
+			continue
+		}
+		if end, isComment := p.tokenEnd(p.pos, p.prev); end > p.pos {
+			if !isComment {
+				p.code(end)
+				continue
+			}
+			if p.stmt < 0 {
+				if err := p.rcvr.FinalBlock(p.src, p.rangeOf(p.pos, end)); err != nil {
+					return err
+				}
+			}
+			p.pos = end
+			continue
+		}
+
+		switch c {
+		case '{':
+			p.code(p.pos + 1)
+			start := p.stmt
+			p.stmt = -1
+			var node sparser.Node
+			if p.lang.Attributes != "" {
+				if attrs := p.attributesEnd(start, p.pos-1); attrs > start && len(bytes.TrimSpace(p.src[attrs:p.pos-1])) > 0 {
+					node.Doc = p.rangeOf(start, attrs)
+					for start = attrs; strings.IndexByte(" \t\r\n", p.src[start]) >= 0; start++ {
+					}
+				}
+			}
+			kind := nodeOf(p.src[start : p.pos-1])
+			node.Kind, node.Name = kind.Kind, kind.Name
+			if err := sparser.StartNode(p.rcvr, p.src, p.rangeOf(start, p.pos), node); err != nil {
+				return err
+			}
+			p.levels = append(p.levels, p.parens)
+			p.parens = 0
+			continue
+		case '}':
+			if len(p.levels) == 0 {
+				// unbalanced, ends the statement
+				p.code(p.pos + 1)
+				if err := p.flush(); err != nil {
+					return err
+				}
+				continue
+			}
+			if err := p.flush(); err != nil {
+				return err
+			}
+			p.parens = p.levels[len(p.levels)-1]
+			p.levels = p.levels[:len(p.levels)-1]
+			start, end := p.pos, p.footerEnd(p.pos)
+			if err := p.rcvr.EndLevel(p.src, p.rangeOf(start, end)); err != nil {
+				return err
+			}
+			p.pos, p.prev = end, p.src[end-1]
+			continue
+		case ';':
+			p.code(p.pos + 1)
+			if p.parens == 0 {
+				if err := p.flush(); err != nil {
+					return err
+				}
+			}
+			continue
+		case '(', '[':
+			p.parens++
+		case ')', ']':
+			if p.parens > 0 {
+				p.parens--
+			}
+		}
+		if isIdentByte(c) {
+			end := p.pos + 1
+			for end < len(p.src) && isIdentByte(p.src[end]) {
+				end++
+			}
+			p.code(end)
+			continue
+		}
+		p.code(p.pos + 1)
+	}
+
+	if err := p.flush(); err != nil {
+		return err
+	}
+	for ; len(p.levels) > 0; p.levels = p.levels[:len(p.levels)-1] {
+		if err := p.rcvr.EndLevel(p.src, sparser.Range{}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+var (
+	controlKeywords = map[string]bool{
+		"if": true, "else": true, "for": true, "foreach": true, "while": true,
+		"do": true, "switch": true, "match": true, "loop": true, "try": true,
+		"catch": true, "finally": true, "unsafe": true, "synchronized": true,
+		"using": true, "lock": true, "fixed": true, "checked": true,
+		"unchecked": true, "static": true,
+	}
+	typeKeywords = map[string]bool{
+		"class": true, "struct": true, "interface": true, "enum": true,
+		"union": true, "namespace": true, "trait": true, "impl": true,
+		"record": true, "mod": true, "module": true,
+	}
+)
+
+// words returns the identifiers in s with their offsets.
+func words(s []byte) (ws []string, offs []int) {
+	for i := 0; i < len(s); i++ {
+		if !isIdentByte(s[i]) {
+			continue
+		}
+		start := i
+		for i < len(s) && isIdentByte(s[i]) {
+			i++
+		}
+		ws, offs = append(ws, string(s[start:i])), append(offs, start)
+	}
+	return ws, offs
+}
+
+// nodeOf guesses the node of a level from the header text before '{'.
+func nodeOf(header []byte) sparser.Node {
+	ws, offs := words(header)
+	if len(ws) == 0 {
+		return sparser.Node{Kind: "block"}
+	}
+	if controlKeywords[ws[0]] && (ws[0] != "static" || len(ws) == 1) {
+		return sparser.Node{Kind: ws[0]}
+	}
+	if trimmed := bytes.TrimSpace(header); bytes.HasSuffix(trimmed, []byte("=>")) || bytes.HasSuffix(trimmed, []byte("->")) {
+		return sparser.Node{Kind: "lambda"}
+	}
+	paren := bytes.IndexByte(header, '(')
+	for i, w := range ws {
+		if paren >= 0 && offs[i] > paren {
+			break
+		}
+		if typeKeywords[w] {
+			node := sparser.Node{Kind: w}
+			if i+1 < len(ws) {
+				node.Name = ws[i+1]
+			}
+			if w == "impl" {
+				// e.g. impl<T> Display for Foo<T>
+				rest := header[offs[i]+len(w):]
+				if rest = bytes.TrimSpace(rest); bytes.HasPrefix(rest, []byte("<")) {
+					if gt := bytes.IndexByte(rest, '>'); gt >= 0 {
+						rest = rest[gt+1:]
+					}
+				}
+				node.Name = strings.Join(strings.Fields(string(rest)), " ")
+			}
+			return node
+		}
+	}
+	if paren > 0 {
+		name := bytes.TrimRight(header[:paren], " \t\r\n")
+		start := len(name)
+		for start > 0 && isIdentByte(name[start-1]) {
+			start--
+		}
+		switch w := string(name[start:]); {
+		case w == "function" || w == "fn":
+			return sparser.Node{Kind: "func"}
+		case w != "" && !controlKeywords[w]:
+			return sparser.Node{Kind: "func", Name: w}
+		}
+	}
+	return sparser.Node{Kind: "block"}
+}
+
+// Parse reports each {...} block as a level, whose header is the statement
+// text before the '{', and statements, comments and preprocessor directives
+// as final blocks. Code not fully understood is reported as is, so it never
+// fails unless the receiver does.
+func (prs Parser) Parse(in io.Reader, rcvr sparser.Receiver) error {
+	src, err := ioutil.ReadAll(in)
+	if err != nil {
+		return err
+	}
+
+	lang := prs.Lang
+	if lang == nil {
+		lang = C
+	}
+	p := &parser{
+		lang:       lang,
+		src:        src,
+		rcvr:       rcvr,
+		lineStarts: []int{0},
+		stmt:       -1,
+	}
+	for i, b := range src {
+		if b == '\n' {
+			p.lineStarts = append(p.lineStarts, i+1)
+		}
+	}
+	return p.parse()
+}
@@ -0,0 +1,187 @@
+package brace
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/daviddengcn/go-assert"
+	"github.com/daviddengcn/go-villa"
+	"github.com/daviddengcn/sgrep/parser"
+)
+
+func parse(t *testing.T, lang *Language, src string) string {
+	act := ""
+	rcvr := sparser.ReceiverFunc{
+		StartLevelFunc: func(buffer []byte, header sparser.Range) error {
+			return nil
+		},
+
+		StartNodeFunc: func(buffer []byte, header sparser.Range, node sparser.Node) error {
+			act += fmt.Sprintf("%d: ", header.MinLine)
+			act += "S " + string(buffer[header.MinOffs:header.MaxOffs+1])
+			act += " (" + node.Kind + " " + node.Name + ")"
+			if !node.Doc.IsEmpty() {
+				act += fmt.Sprintf(" (%d: %s)", node.Doc.MinLine, buffer[node.Doc.MinOffs:node.Doc.MaxOffs+1])
+			}
+			act += "\n"
+			return nil
+		},
+
+		FinalBlockFunc: func(buffer []byte, body sparser.Range) error {
+			act += fmt.Sprintf("%d: ", body.MinLine)
+			act += "F " + string(buffer[body.MinOffs:body.MaxOffs+1]) + "\n"
+			return nil
+		},
+
+		EndLevelFunc: func(buffer []byte, footer sparser.Range) error {
+			if footer.IsEmpty() {
+				act += "E\n"
+				return nil
+			}
+			act += fmt.Sprintf("%d: ", footer.MinLine)
+			act += "E " + string(buffer[footer.MinOffs:footer.MaxOffs+1]) + "\n"
+			return nil
+		},
+	}
+
+	srcBytes := villa.ByteSlice(src)
+	assert.NoError(t, Parser{Lang: lang}.Parse(&srcBytes, rcvr))
+	return act
+}
+
+func TestJava(t *testing.T) {
+	src := `package a;
+
+/** Doc */
+public class Foo {
+    @Override
+    public void bar() {
+        int x = 1; // one
+        if (x > 0) {
+            call("}");
+        } else {
+            list.forEach(y -> {
+                other(y);
+            });
+        }
+    }
+}
+`
+	exp := `1: F package a;
+3: F /** Doc */
+4: S public class Foo { (class Foo)
+6: S public void bar() { (func bar) (5: @Override)
+7: F int x = 1;
+7: F // one
+8: S if (x > 0) { (if )
+9: F call("}");
+10: E }
+10: S else { (else )
+11: S list.forEach(y -> { (lambda )
+12: F other(y);
+13: E });
+14: E }
+15: E }
+16: E }
+`
+	assert.TextEquals(t, "act", parse(t, Java, src), exp)
+}
+
+func TestTokens(t *testing.T) {
+	js := "const re = /[{]/g;\n" +
+		"function f(a) {\n" +
+		"  return `x${ {a: 1}.a }}` + /}/.source;\n" +
+		"}\n" +
+		"x = a / b / c;\n"
+	assert.TextEquals(t, "js", parse(t, JavaScript, js), "1: F const re = /[{]/g;\n"+
+		"2: S function f(a) { (func f)\n"+
+		"3: F return `x${ {a: 1}.a }}` + /}/.source;\n"+
+		"4: E }\n"+
+		"5: F x = a / b / c;\n")
+
+	rs := `/* outer /* inner { */ */
+#[cfg(test)]
+#[derive(Debug, Clone)]
+impl<'a, T> Display for Foo<'a, T> {
+    fn fmt(&self) -> &'a str {
+        let c = '{';
+        r#"}"#
+    }
+}
+`
+	assert.TextEquals(t, "rs", parse(t, Rust, rs), `1: F /* outer /* inner { */ */
+4: S impl<'a, T> Display for Foo<'a, T> { (impl Display for Foo<'a, T>) (2: #[cfg(test)]
+#[derive(Debug, Clone)])
+5: S fn fmt(&self) -> &'a str { (func fmt)
+6: F let c = '{';
+7: F r#"}"#
+8: E }
+9: E }
+`)
+
+	c := `#define X {\
+  1 }
+int a[] = {1, 2};
+struct s {
+  int x;
+} v;
+`
+	assert.TextEquals(t, "c", parse(t, C, c), `1: F #define X {\
+  1 }
+3: S int a[] = { (block )
+3: F 1, 2
+3: E };
+4: S struct s { (struct s)
+5: F int x;
+6: E }
+6: F v;
+`)
+
+	cs := `var s = @"C:\{";
+namespace N {
+`
+	assert.TextEquals(t, "cs", parse(t, CSharp, cs), `1: F var s = @"C:\{";
+2: S namespace N { (namespace N)
+E
+`)
+}
+
+func TestLineBreaks(t *testing.T) {
+	src := `import x from 'y'
+const a = 1
+// TODO
+const b = a / 2
+function f() {
+  i++
+  return a
+    .map(v => v + 1)
+  if (a)
+    g()
+}
+`
+	assert.TextEquals(t, "js", parse(t, JavaScript, src), `1: F import x from 'y'
+2: F const a = 1
+3: F // TODO
+4: F const b = a / 2
+5: S function f() { (func f)
+6: F i++
+7: F return a
+    .map(v => v + 1)
+9: F if (a)
+    g()
+11: E }
+`)
+
+	// line breaks don't end statements in other languages
+	assert.TextEquals(t, "java", parse(t, Java, "int a = 1\nint b;\n"), "1: F int a = 1\nint b;\n")
+}
+
+func TestUnbalanced(t *testing.T) {
+	assert.TextEquals(t, "act", parse(t, Java, "} a; }\n{ b\n"), `1: F }
+1: F a;
+1: F }
+2: S { (block )
+2: F b
+E
+`)
+}
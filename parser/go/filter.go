@@ -0,0 +1,75 @@
+package goparser
+
+import (
+	"bytes"
+	"go/build"
+	"io/ioutil"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+// Filter selects Go files by build constraints, and whether they are test
+// files or generated files.
+type Filter struct {
+	// The target OS of build constraints. The current OS if empty.
+	GOOS string
+	// Build tags satisfied. Build constraints are checked only if GOOS or
+	// Tags is set.
+	Tags []string
+	// Whether to skip _test.go files.
+	NoTests bool
+	// Whether to skip generated files, i.e. files with a comment line like
+	// "// Code generated ... DO NOT EDIT." before the package clause.
+	SkipGenerated bool
+}
+
+var generatedRe = regexp.MustCompile(`^// Code generated .* DO NOT EDIT\.$`)
+
+// IsGenerated returns true if the Go source src has the comment of generated
+// files before its package clause.
+func IsGenerated(src []byte) bool {
+	for len(src) > 0 {
+		line := src
+		if i := bytes.IndexByte(src, '\n'); i >= 0 {
+			line, src = src[:i], src[i+1:]
+		} else {
+			src = nil
+		}
+		line = bytes.TrimSuffix(line, []byte("\r"))
+		if generatedRe.Match(line) {
+			return true
+		}
+		if bytes.HasPrefix(line, []byte("package ")) {
+			break
+		}
+	}
+	return false
+}
+
+// Match returns true if the Go file fn is selected by f.
+func (f *Filter) Match(fn string) (bool, error) {
+	if f.NoTests && strings.HasSuffix(fn, "_test.go") {
+		return false, nil
+	}
+	if f.GOOS != "" || len(f.Tags) > 0 {
+		ctxt := build.Default
+		if f.GOOS != "" {
+			ctxt.GOOS = f.GOOS
+		}
+		ctxt.BuildTags = f.Tags
+		if ok, err := ctxt.MatchFile(filepath.Dir(fn), filepath.Base(fn)); err != nil || !ok {
+			return false, err
+		}
+	}
+	if f.SkipGenerated {
+		src, err := ioutil.ReadFile(fn)
+		if err != nil {
+			return false, err
+		}
+		if IsGenerated(src) {
+			return false, nil
+		}
+	}
+	return true, nil
+}
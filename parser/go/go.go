@@ -10,7 +10,11 @@ import (
 	"github.com/daviddengcn/sgrep/parser"
 )
 
-type Parser struct{}
+type Parser struct {
+	// If set, all methods of a type are grouped, at the first one, in a level
+	// of the type, which has the node of kind "type" without a header.
+	GroupMethods bool
+}
 
 func init() {
 	sparser.Register("go", func() (sparser.Parser, error) {
@@ -39,92 +43,522 @@ func maxOfFieldLists(fl *ast.FieldList) token.Pos {
 	return fl.List[len(fl.List)-1].End() - 1
 }
 
-func (Parser) Parse(in io.Reader, rcvr sparser.Receiver) error {
-	src, err := ioutil.ReadAll(in)
-	if err != nil {
-		return err
+// recvTypeName returns the name of the receiver type of a method, or "" for a
+// function.
+func recvTypeName(d *ast.FuncDecl) string {
+	if d.Recv == nil || len(d.Recv.List) == 0 {
+		return ""
+	}
+	typ := d.Recv.List[0].Type
+	for {
+		switch t := typ.(type) {
+		case *ast.Ident:
+			return t.Name
+		case *ast.StarExpr:
+			typ = t.X
+		case *ast.ParenExpr:
+			typ = t.X
+		case *ast.IndexExpr:
+			typ = t.X
+		case *ast.IndexListExpr:
+			typ = t.X
+		default:
+			return ""
+		}
 	}
+}
 
-	fs := token.NewFileSet()
-	f, err := parser.ParseFile(fs, "", string(src), 0)
-	if err != nil {
-		return err
+// specNode returns the node of a spec declared with tok.
+func specNode(tok token.Token, spec ast.Spec) sparser.Node {
+	node := sparser.Node{Kind: tok.String()}
+	switch spec := spec.(type) {
+	case *ast.TypeSpec:
+		node.Name = spec.Name.Name
+	case *ast.ValueSpec:
+		node.Name = spec.Names[0].Name
+	case *ast.ImportSpec:
+		node.Name = spec.Path.Value
 	}
+	return node
+}
 
-	if err := rcvr.StartLevel(src, rangeOfPos(fs, f.Package, f.Name.End()-1)); err != nil {
-		return err
+// specDoc returns the doc comment of a spec in a group.
+func specDoc(spec ast.Spec) *ast.CommentGroup {
+	switch spec := spec.(type) {
+	case *ast.TypeSpec:
+		return spec.Doc
+	case *ast.ValueSpec:
+		return spec.Doc
+	case *ast.ImportSpec:
+		return spec.Doc
 	}
-	for _, decl := range f.Decls {
-		switch d := decl.(type) {
+	return nil
+}
+
+// fieldList returns the fields of a multi-line struct or interface type, or
+// nil for other types.
+func fieldList(fs *token.FileSet, typ ast.Expr) *ast.FieldList {
+	var fl *ast.FieldList
+	switch t := typ.(type) {
+	case *ast.StructType:
+		fl = t.Fields
+	case *ast.InterfaceType:
+		fl = t.Methods
+	}
+	if fl == nil || fs.Position(fl.Opening).Line == fs.Position(fl.Closing).Line {
+		return nil
+	}
+	return fl
+}
+
+// docComments returns the doc comments of the declarations, specs and fields
+// in f, which are sent with them.
+func docComments(fs *token.FileSet, f *ast.File) map[*ast.CommentGroup]bool {
+	docs := map[*ast.CommentGroup]bool{f.Doc: true}
+	ast.Inspect(f, func(n ast.Node) bool {
+		switch n := n.(type) {
 		case *ast.FuncDecl:
-			endOfFunc := token.NoPos
-			if d.Body != nil {
-				endOfFunc = d.Body.Lbrace
-			}
-			if !endOfFunc.IsValid() {
-				endOfFunc = maxOfFieldLists(d.Type.Results)
-			}
-			if !endOfFunc.IsValid() {
-				endOfFunc = maxOfFieldLists(d.Type.Params)
-			}
-			header := rangeOfPos(fs, d.Type.Func, endOfFunc)
-			var body sparser.Range
-			if d.Body != nil && len(d.Body.List) > 0 {
-				list := d.Body.List
-				body = rangeOfPos(fs, list[0].Pos(), list[len(list)-1].End()-1)
+			docs[n.Doc] = true
+		case *ast.GenDecl:
+			docs[n.Doc] = true
+			if n.Lparen.IsValid() {
+				for _, spec := range n.Specs {
+					docs[specDoc(spec)] = true
+				}
 			}
-			var footer sparser.Range
-			if d.Body != nil {
-				footer = rangeOfPos(fs, d.Body.Rbrace, d.Body.Rbrace)
+		case *ast.StructType, *ast.InterfaceType:
+			if fl := fieldList(fs, n.(ast.Expr)); fl != nil {
+				for _, field := range fl.List {
+					docs[field.Doc] = true
+				}
 			}
-			if err := rcvr.StartLevel(src, header); err != nil {
-				return err
+		}
+		return true
+	})
+	return docs
+}
+
+// walker sends the levels and blocks of a Go file to rcvr.
+type walker struct {
+	fs   *token.FileSet
+	src  []byte
+	rcvr sparser.Receiver
+	// comment groups not sent yet, except doc comments
+	comments []*ast.CommentGroup
+}
+
+func (w *walker) rangeOf(min, max token.Pos) sparser.Range {
+	return rangeOfPos(w.fs, min, max)
+}
+
+func (w *walker) isMultiline(min, max token.Pos) bool {
+	return w.fs.Position(min).Line != w.fs.Position(max).Line
+}
+
+// docRange returns the range of a doc comment, which could be nil.
+func (w *walker) docRange(doc *ast.CommentGroup) sparser.Range {
+	if doc == nil {
+		return sparser.Range{}
+	}
+	return w.rangeOf(doc.Pos(), doc.End()-1)
+}
+
+// flushComments sends the comment groups ending before pos as final blocks.
+func (w *walker) flushComments(pos token.Pos) error {
+	for len(w.comments) > 0 && w.comments[0].End() <= pos {
+		c := w.comments[0]
+		w.comments = w.comments[1:]
+		if err := w.rcvr.FinalBlock(w.src, w.rangeOf(c.Pos(), c.End()-1)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// skipComments drops the comment groups starting before pos, which are in
+// the blocks sent.
+func (w *walker) skipComments(pos token.Pos) {
+	for len(w.comments) > 0 && w.comments[0].Pos() < pos {
+		w.comments = w.comments[1:]
+	}
+}
+
+// block sends a level of header, the statements in body and the footer of the
+// closing brace.
+func (w *walker) block(header sparser.Range, node sparser.Node, body *ast.BlockStmt) error {
+	if err := sparser.StartNode(w.rcvr, w.src, header, node); err != nil {
+		return err
+	}
+	if err := w.stmts(body.List); err != nil {
+		return err
+	}
+	if err := w.flushComments(body.Rbrace); err != nil {
+		return err
+	}
+	return w.rcvr.EndLevel(w.src, w.rangeOf(body.Rbrace, body.Rbrace))
+}
+
+func (w *walker) stmts(list []ast.Stmt) error {
+	for _, stmt := range list {
+		if err := w.flushComments(stmt.Pos()); err != nil {
+			return err
+		}
+		if err := w.stmt(stmt); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ifStmt sends an if statement whose header starts at start, and its else
+// branches as sibling levels.
+func (w *walker) ifStmt(s *ast.IfStmt, start token.Pos, node sparser.Node) error {
+	if err := w.block(w.rangeOf(start, s.Body.Lbrace), node, s.Body); err != nil {
+		return err
+	}
+	// the else header starts after the closing brace, e.g. " else {"
+	switch e := s.Else.(type) {
+	case *ast.IfStmt:
+		return w.ifStmt(e, s.Body.Rbrace+1, sparser.Node{Kind: "else"})
+	case *ast.BlockStmt:
+		return w.block(w.rangeOf(s.Body.Rbrace+1, e.Lbrace), sparser.Node{Kind: "else"}, e)
+	}
+	return nil
+}
+
+// clause sends a case clause as a level without footer.
+func (w *walker) clause(header sparser.Range, body []ast.Stmt) error {
+	if err := sparser.StartNode(w.rcvr, w.src, header, sparser.Node{Kind: "case"}); err != nil {
+		return err
+	}
+	if err := w.stmts(body); err != nil {
+		return err
+	}
+	return w.rcvr.EndLevel(w.src, sparser.Range{})
+}
+
+func (w *walker) stmt(s ast.Stmt) error {
+	switch s := s.(type) {
+	case *ast.BlockStmt:
+		return w.block(w.rangeOf(s.Lbrace, s.Lbrace), sparser.Node{Kind: "block"}, s)
+	case *ast.IfStmt:
+		return w.ifStmt(s, s.If, sparser.Node{Kind: "if"})
+	case *ast.ForStmt:
+		return w.block(w.rangeOf(s.For, s.Body.Lbrace), sparser.Node{Kind: "for"}, s.Body)
+	case *ast.RangeStmt:
+		return w.block(w.rangeOf(s.For, s.Body.Lbrace), sparser.Node{Kind: "for"}, s.Body)
+	case *ast.SwitchStmt:
+		return w.block(w.rangeOf(s.Switch, s.Body.Lbrace), sparser.Node{Kind: "switch"}, s.Body)
+	case *ast.TypeSwitchStmt:
+		return w.block(w.rangeOf(s.Switch, s.Body.Lbrace), sparser.Node{Kind: "switch"}, s.Body)
+	case *ast.SelectStmt:
+		return w.block(w.rangeOf(s.Select, s.Body.Lbrace), sparser.Node{Kind: "select"}, s.Body)
+	case *ast.CaseClause:
+		return w.clause(w.rangeOf(s.Case, s.Colon), s.Body)
+	case *ast.CommClause:
+		return w.clause(w.rangeOf(s.Case, s.Colon), s.Body)
+	case *ast.LabeledStmt:
+		if err := w.rcvr.FinalBlock(w.src, w.rangeOf(s.Label.Pos(), s.Colon)); err != nil {
+			return err
+		}
+		return w.stmt(s.Stmt)
+	}
+	return w.leaf(s.Pos(), s.End()-1, s, sparser.Node{})
+}
+
+// fields sends a level of header, the fields in fl, and the footer from the
+// closing brace to max.
+func (w *walker) fields(header sparser.Range, node sparser.Node, fl *ast.FieldList, max token.Pos) error {
+	if err := sparser.StartNode(w.rcvr, w.src, header, node); err != nil {
+		return err
+	}
+	for _, field := range fl.List {
+		if err := w.flushComments(field.Pos()); err != nil {
+			return err
+		}
+		if err := w.field(field); err != nil {
+			return err
+		}
+	}
+	if err := w.flushComments(fl.Closing); err != nil {
+		return err
+	}
+	return w.rcvr.EndLevel(w.src, w.rangeOf(fl.Closing, max))
+}
+
+// field sends a field of a struct or a method of an interface.
+func (w *walker) field(f *ast.Field) error {
+	node := sparser.Node{Kind: "field", Doc: w.docRange(f.Doc)}
+	if len(f.Names) > 0 {
+		node.Name = f.Names[0].Name
+	}
+	if fl := fieldList(w.fs, f.Type); fl != nil {
+		return w.fields(w.rangeOf(f.Pos(), fl.Opening), node, fl, f.End()-1)
+	}
+	return w.leaf(f.Pos(), f.End()-1, f, node)
+}
+
+// spec sends a spec starting at start, as a level if it's a struct or
+// interface type.
+func (w *walker) spec(spec ast.Spec, start token.Pos, node sparser.Node) error {
+	if ts, ok := spec.(*ast.TypeSpec); ok {
+		if fl := fieldList(w.fs, ts.Type); fl != nil {
+			return w.fields(w.rangeOf(start, fl.Opening), node, fl, spec.End()-1)
+		}
+	}
+	return w.leaf(start, spec.End()-1, spec, node)
+}
+
+// literals returns the outermost multi-line function and composite literals
+// in n.
+func (w *walker) literals(n ast.Node) []ast.Expr {
+	var lits []ast.Expr
+	ast.Inspect(n, func(n ast.Node) bool {
+		switch n := n.(type) {
+		case *ast.FuncLit:
+			if w.isMultiline(n.Body.Lbrace, n.Body.Rbrace) {
+				lits = append(lits, n)
+				return false
 			}
-			if err := rcvr.FinalBlock(src, body); err != nil {
-				return err
+		case *ast.CompositeLit:
+			if w.isMultiline(n.Lbrace, n.Rbrace) {
+				lits = append(lits, n)
+				return false
 			}
-			if err := rcvr.EndLevel(src, footer); err != nil {
+		}
+		return true
+	})
+	return lits
+}
+
+// leaf sends n in [min, max] as a final block, or as levels of the multi-line
+// literals in it. The text before a literal is the header of its level, and
+// the text after the last one is in the footer of the last level. node, if its
+// Kind is not empty, is the node of the first level. Its doc is included in
+// the final block.
+func (w *walker) leaf(min, max token.Pos, n ast.Node, node sparser.Node) error {
+	lits := w.literals(n)
+	if len(lits) == 0 {
+		w.skipComments(max)
+		rg := w.rangeOf(min, max)
+		if !node.Doc.IsEmpty() {
+			rg.MinOffs, rg.MinLine = node.Doc.MinOffs, node.Doc.MinLine
+		}
+		return w.rcvr.FinalBlock(w.src, rg)
+	}
+	start := min
+	for i, lit := range lits {
+		var lbrace, rbrace token.Pos
+		var litNode sparser.Node
+		switch lit := lit.(type) {
+		case *ast.FuncLit:
+			lbrace, rbrace = lit.Body.Lbrace, lit.Body.Rbrace
+			litNode.Kind = "func"
+		case *ast.CompositeLit:
+			lbrace, rbrace = lit.Lbrace, lit.Rbrace
+			litNode.Kind = "literal"
+		}
+		if i == 0 && node.Kind != "" {
+			litNode = node
+		}
+		if err := sparser.StartNode(w.rcvr, w.src, w.rangeOf(start, lbrace), litNode); err != nil {
+			return err
+		}
+		switch lit := lit.(type) {
+		case *ast.FuncLit:
+			if err := w.stmts(lit.Body.List); err != nil {
 				return err
 			}
-		case *ast.GenDecl:
-			if d.Lparen.IsValid() && len(d.Specs) > 0 {
-				header := rangeOfPos(fs, d.TokPos, d.Lparen)
-				body := rangeOfPos(fs, d.Specs[0].Pos(), d.Specs[len(d.Specs)-1].End() - 1)
-				footer := rangeOfPos(fs, d.Rparen, d.Rparen)
-				
-				if err := rcvr.StartLevel(src, header); err != nil {
+		case *ast.CompositeLit:
+			for _, elt := range lit.Elts {
+				if err := w.flushComments(elt.Pos()); err != nil {
 					return err
 				}
-				if err := rcvr.FinalBlock(src, body); err != nil {
+				if err := w.leaf(elt.Pos(), elt.End()-1, elt, sparser.Node{}); err != nil {
 					return err
 				}
-				if err := rcvr.EndLevel(src, footer); err != nil {
-					return err
-				}
-			} else if len(d.Specs) == 1 && d.Tok != token.IMPORT {
-				header := rangeOfPos(fs, d.TokPos, d.TokPos + token.Pos(len(d.Tok.String()) - 1))
-				body := rangeOfPos(fs, d.Specs[0].Pos(), d.Specs[len(d.Specs)-1].End() - 2)
-				footer := rangeOfPos(fs, d.Specs[0].End() - 1, d.Specs[0].End() - 1)
-				
-				if err := rcvr.StartLevel(src, header); err != nil {
-					return err
-				}
-				if err := rcvr.FinalBlock(src, body); err != nil {
+			}
+		}
+		if err := w.flushComments(rbrace); err != nil {
+			return err
+		}
+		end := rbrace
+		if i == len(lits)-1 {
+			end = max
+		}
+		if err := w.rcvr.EndLevel(w.src, w.rangeOf(rbrace, end)); err != nil {
+			return err
+		}
+		start = rbrace + 1
+	}
+	return nil
+}
+
+// decl sends a top level declaration.
+func (w *walker) decl(decl ast.Decl) error {
+	switch d := decl.(type) {
+	case *ast.FuncDecl:
+		endOfFunc := token.NoPos
+		if d.Body != nil {
+			endOfFunc = d.Body.Lbrace
+		}
+		if !endOfFunc.IsValid() {
+			endOfFunc = maxOfFieldLists(d.Type.Results)
+		}
+		if !endOfFunc.IsValid() {
+			endOfFunc = maxOfFieldLists(d.Type.Params)
+		}
+		header := w.rangeOf(d.Type.Func, endOfFunc)
+		node := sparser.Node{Kind: "func", Name: d.Name.Name, Doc: w.docRange(d.Doc)}
+		if d.Recv != nil {
+			node.Kind = "method"
+		}
+		if d.Body != nil {
+			if err := w.block(header, node, d.Body); err != nil {
+				return err
+			}
+			break
+		}
+		if err := sparser.StartNode(w.rcvr, w.src, header, node); err != nil {
+			return err
+		}
+		if err := w.rcvr.EndLevel(w.src, sparser.Range{}); err != nil {
+			return err
+		}
+	case *ast.GenDecl:
+		if d.Lparen.IsValid() {
+			header := w.rangeOf(d.TokPos, d.Lparen)
+			node := sparser.Node{Kind: d.Tok.String(), Doc: w.docRange(d.Doc)}
+			if err := sparser.StartNode(w.rcvr, w.src, header, node); err != nil {
+				return err
+			}
+			for _, spec := range d.Specs {
+				if err := w.flushComments(spec.Pos()); err != nil {
 					return err
 				}
-				if err := rcvr.EndLevel(src, footer); err != nil {
+				node := specNode(d.Tok, spec)
+				node.Doc = w.docRange(specDoc(spec))
+				if err := w.spec(spec, spec.Pos(), node); err != nil {
 					return err
 				}
+			}
+			if err := w.flushComments(d.Rparen); err != nil {
+				return err
+			}
+			if err := w.rcvr.EndLevel(w.src, w.rangeOf(d.Rparen, d.Rparen)); err != nil {
+				return err
+			}
+		} else if len(d.Specs) == 1 {
+			node := specNode(d.Tok, d.Specs[0])
+			node.Doc = w.docRange(d.Doc)
+			if err := w.spec(d.Specs[0], d.TokPos, node); err != nil {
+				return err
+			}
+		} else {
+			if err := w.rcvr.FinalBlock(w.src, w.rangeOf(d.Pos(), d.End()-1)); err != nil {
+				return err
+			}
+		}
+	default:
+		if err := w.rcvr.FinalBlock(w.src, w.rangeOf(d.Pos(), d.End()-1)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// methodGroup sends the methods of type recv in a level of the type. A method
+// after other declarations is sent with only the comments inside it, leaving
+// the others to the declarations around it.
+func (w *walker) methodGroup(recv string, methods []*ast.FuncDecl) error {
+	if err := sparser.StartNode(w.rcvr, w.src, sparser.Range{}, sparser.Node{Kind: "type", Name: recv}); err != nil {
+		return err
+	}
+	for _, d := range methods {
+		var inside, outside []*ast.CommentGroup
+		for _, c := range w.comments {
+			if c.Pos() >= d.Pos() && c.End() <= d.End() {
+				inside = append(inside, c)
 			} else {
-				if err := rcvr.FinalBlock(src, rangeOfPos(fs, d.Pos(), d.End()-1)); err != nil {
-					return err
+				outside = append(outside, c)
+			}
+		}
+		w.comments = inside
+		if err := w.decl(d); err != nil {
+			return err
+		}
+		w.comments = outside
+	}
+	return w.rcvr.EndLevel(w.src, sparser.Range{})
+}
+
+func (p Parser) Parse(in io.Reader, rcvr sparser.Receiver) error {
+	src, err := ioutil.ReadAll(in)
+	if err != nil {
+		return err
+	}
+
+	fs := token.NewFileSet()
+	f, err := parser.ParseFile(fs, "", string(src), parser.ParseComments)
+	if err != nil {
+		return err
+	}
+
+	w := &walker{
+		fs:   fs,
+		src:  src,
+		rcvr: rcvr,
+	}
+	docs := docComments(fs, f)
+	for _, c := range f.Comments {
+		if !docs[c] {
+			w.comments = append(w.comments, c)
+		}
+	}
+
+	if err := w.flushComments(f.Package); err != nil {
+		return err
+	}
+	if err := sparser.StartNode(rcvr, src, rangeOfPos(fs, f.Package, f.Name.End()-1), sparser.Node{Kind: "package", Name: f.Name.Name, Doc: w.docRange(f.Doc)}); err != nil {
+		return err
+	}
+	// the methods of each receiver type, which are grouped at the first one
+	methods := map[string][]*ast.FuncDecl{}
+	if p.GroupMethods {
+		for _, decl := range f.Decls {
+			if d, ok := decl.(*ast.FuncDecl); ok {
+				if recv := recvTypeName(d); recv != "" {
+					methods[recv] = append(methods[recv], d)
 				}
 			}
-		default:
-			if err := rcvr.FinalBlock(src, rangeOfPos(fs, d.Pos(), d.End()-1)); err != nil {
+		}
+	}
+	for _, decl := range f.Decls {
+		recv := ""
+		if d, ok := decl.(*ast.FuncDecl); ok && p.GroupMethods {
+			recv = recvTypeName(d)
+		}
+		if recv != "" && methods[recv] == nil {
+			// sent in the group
+			continue
+		}
+		if err := w.flushComments(decl.Pos()); err != nil {
+			return err
+		}
+		if recv != "" {
+			if err := w.methodGroup(recv, methods[recv]); err != nil {
 				return err
 			}
+			methods[recv] = nil
+			continue
+		}
+		if err := w.decl(decl); err != nil {
+			return err
 		}
+		w.skipComments(decl.End())
+	}
+	if err := w.flushComments(fs.File(f.Package).Pos(len(src))); err != nil {
+		return err
 	}
 
 	if err := rcvr.EndLevel(src, sparser.Range{}); err != nil {
@@ -26,10 +26,8 @@ func Foo() {
 	exp :=
 `1: S package example
 3: F import "testing"
-5: S type
-5: F T struct {
-	Field int
-
+5: S type T struct {
+6: F Field int
 7: E }
 9: S func Foo() {
 10: F Hello
@@ -71,3 +69,315 @@ func Foo() {
 	
 	assert.TextEquals(t, "act", act, exp)
 }
+
+func TestNodes(t *testing.T) {
+	src := `package example
+
+type T struct {
+	F int
+}
+
+const (
+	A = 1
+	B = T{
+		F: 1,
+	}
+)
+
+func (T) M() {}
+
+func F() {}
+`
+	var act []string
+	rcvr := sparser.ReceiverFunc{
+		StartLevelFunc: func(buffer []byte, header sparser.Range) error {
+			return nil
+		},
+		StartNodeFunc: func(buffer []byte, header sparser.Range, node sparser.Node) error {
+			act = append(act, node.Kind+" "+node.Name)
+			return nil
+		},
+		FinalBlockFunc: func(buffer []byte, body sparser.Range) error {
+			return nil
+		},
+		EndLevelFunc: func(buffer []byte, footer sparser.Range) error {
+			return nil
+		},
+	}
+	srcBytes := villa.ByteSlice(src)
+	assert.NoError(t, Parser{}.Parse(&srcBytes, rcvr))
+	assert.StringEquals(t, "nodes", act, []string{"package example", "type T", "const ", "const B", "method M", "func F"})
+}
+
+func TestBody(t *testing.T) {
+	src := `package example
+
+func Foo(ch chan int) {
+	if a {
+		A()
+	} else if b {
+		B()
+	} else {
+		C()
+	}
+	for i := range ch {
+		switch i {
+		case 1:
+			One()
+		}
+	}
+	go func() {
+		Go()
+	}()
+	x := T{
+		1,
+		{
+			2,
+		},
+	}
+}`
+
+	exp := `1: S package example
+3: S func Foo(ch chan int) {
+4: S if a {
+5: F A()
+6: E }
+6: S  else if b {
+7: F B()
+8: E }
+8: S  else {
+9: F C()
+10: E }
+11: S for i := range ch {
+12: S switch i {
+13: S case 1:
+14: F One()
+15: E }
+16: E }
+17: S go func() {
+18: F Go()
+19: E }()
+20: S x := T{
+21: F 1
+22: S {
+23: F 2
+24: E }
+25: E }
+26: E }
+`
+
+	act := ""
+	rcvr := sparser.ReceiverFunc{
+		StartLevelFunc: func(buffer []byte, header sparser.Range) error {
+			if !header.IsEmpty() {
+				act += fmt.Sprintf("%d: S %s\n", header.MinLine, buffer[header.MinOffs:header.MaxOffs+1])
+			}
+			return nil
+		},
+		FinalBlockFunc: func(buffer []byte, body sparser.Range) error {
+			if !body.IsEmpty() {
+				act += fmt.Sprintf("%d: F %s\n", body.MinLine, buffer[body.MinOffs:body.MaxOffs+1])
+			}
+			return nil
+		},
+		EndLevelFunc: func(buffer []byte, footer sparser.Range) error {
+			if !footer.IsEmpty() {
+				act += fmt.Sprintf("%d: E %s\n", footer.MinLine, buffer[footer.MinOffs:footer.MaxOffs+1])
+			}
+			return nil
+		},
+	}
+	srcBytes := villa.ByteSlice(src)
+	assert.NoError(t, Parser{}.Parse(&srcBytes, rcvr))
+	assert.TextEquals(t, "act", act, exp)
+}
+
+func TestComments(t *testing.T) {
+	src := `// Copyright
+
+// Package example.
+package example
+
+// Foo is deprecated.
+//
+// Deprecated: use Bar.
+func Foo() {
+	// TODO: remove
+	A() // trailing
+	/* last */
+}
+
+// the end
+`
+
+	exp := `1: F // Copyright
+4: S package example (3: // Package example.)
+9: S func Foo() { (6: // Foo is deprecated.
+//
+// Deprecated: use Bar.)
+10: F // TODO: remove
+11: F A()
+11: F // trailing
+12: F /* last */
+13: E }
+15: F // the end
+`
+
+	act := ""
+	rcvr := sparser.ReceiverFunc{
+		StartLevelFunc: func(buffer []byte, header sparser.Range) error {
+			return nil
+		},
+		StartNodeFunc: func(buffer []byte, header sparser.Range, node sparser.Node) error {
+			act += fmt.Sprintf("%d: S %s", header.MinLine, buffer[header.MinOffs:header.MaxOffs+1])
+			if !node.Doc.IsEmpty() {
+				act += fmt.Sprintf(" (%d: %s)", node.Doc.MinLine, buffer[node.Doc.MinOffs:node.Doc.MaxOffs+1])
+			}
+			act += "\n"
+			return nil
+		},
+		FinalBlockFunc: func(buffer []byte, body sparser.Range) error {
+			if !body.IsEmpty() {
+				act += fmt.Sprintf("%d: F %s\n", body.MinLine, buffer[body.MinOffs:body.MaxOffs+1])
+			}
+			return nil
+		},
+		EndLevelFunc: func(buffer []byte, footer sparser.Range) error {
+			if !footer.IsEmpty() {
+				act += fmt.Sprintf("%d: E %s\n", footer.MinLine, buffer[footer.MinOffs:footer.MaxOffs+1])
+			}
+			return nil
+		},
+	}
+	srcBytes := villa.ByteSlice(src)
+	assert.NoError(t, Parser{}.Parse(&srcBytes, rcvr))
+	assert.TextEquals(t, "act", act, exp)
+}
+
+func TestTypes(t *testing.T) {
+	src := `package example
+
+import (
+	"fmt"
+	"io"
+)
+
+type (
+	// T is a struct.
+	T struct {
+		// A is a field.
+		A int
+		B struct {
+			C string
+		}
+	}
+	I interface {
+		M()
+	}
+)`
+
+	exp := `1: S package example
+3: S import (
+4: F "fmt"
+5: F "io"
+6: E )
+8: S type (
+10: S T struct { (9: // T is a struct.)
+11: F // A is a field.
+		A int
+13: S B struct {
+14: F C string
+15: E }
+16: E }
+17: S I interface {
+18: F M()
+19: E }
+20: E )
+`
+
+	act := ""
+	rcvr := sparser.ReceiverFunc{
+		StartLevelFunc: func(buffer []byte, header sparser.Range) error {
+			return nil
+		},
+		StartNodeFunc: func(buffer []byte, header sparser.Range, node sparser.Node) error {
+			act += fmt.Sprintf("%d: S %s", header.MinLine, buffer[header.MinOffs:header.MaxOffs+1])
+			if !node.Doc.IsEmpty() {
+				act += fmt.Sprintf(" (%d: %s)", node.Doc.MinLine, buffer[node.Doc.MinOffs:node.Doc.MaxOffs+1])
+			}
+			act += "\n"
+			return nil
+		},
+		FinalBlockFunc: func(buffer []byte, body sparser.Range) error {
+			if !body.IsEmpty() {
+				act += fmt.Sprintf("%d: F %s\n", body.MinLine, buffer[body.MinOffs:body.MaxOffs+1])
+			}
+			return nil
+		},
+		EndLevelFunc: func(buffer []byte, footer sparser.Range) error {
+			if !footer.IsEmpty() {
+				act += fmt.Sprintf("%d: E %s\n", footer.MinLine, buffer[footer.MinOffs:footer.MaxOffs+1])
+			}
+			return nil
+		},
+	}
+	srcBytes := villa.ByteSlice(src)
+	assert.NoError(t, Parser{}.Parse(&srcBytes, rcvr))
+	assert.TextEquals(t, "act", act, exp)
+}
+
+func TestGroupMethods(t *testing.T) {
+	src := `package example
+
+// free-standing
+
+func (s *Server) Serve() {}
+
+// Close closes s.
+func (s Server) Close() {}
+
+func F() {}
+
+func (c *Client) Do() {}
+
+// before Stop
+
+func (s *Server) Stop() {
+	// inside Stop
+}
+`
+	var act []string
+	rcvr := sparser.ReceiverFunc{
+		StartLevelFunc: func(buffer []byte, header sparser.Range) error {
+			return nil
+		},
+		StartNodeFunc: func(buffer []byte, header sparser.Range, node sparser.Node) error {
+			act = append(act, "S "+node.Kind+" "+node.Name)
+			return nil
+		},
+		FinalBlockFunc: func(buffer []byte, body sparser.Range) error {
+			act = append(act, "F")
+			return nil
+		},
+		EndLevelFunc: func(buffer []byte, footer sparser.Range) error {
+			act = append(act, "E")
+			return nil
+		},
+	}
+	srcBytes := villa.ByteSlice(src)
+	assert.NoError(t, Parser{GroupMethods: true}.Parse(&srcBytes, rcvr))
+	assert.StringEquals(t, "act", act, []string{"S package example", "F",
+		"S type Server", "S method Serve", "E", "S method Close", "E",
+		// methods after other declarations are grouped too
+		"S method Stop", "F", "E", "E",
+		"S func F", "E",
+		"S type Client", "S method Do", "E", "E",
+		"F",
+		"E"})
+}
+
+func TestIsGenerated(t *testing.T) {
+	assert.IsTrue(t, "generated", IsGenerated([]byte("// Code generated by protoc-gen-go. DO NOT EDIT.\n\npackage pb\n")))
+	assert.IsFalse(t, "after package", IsGenerated([]byte("package pb\n\n// Code generated by protoc-gen-go. DO NOT EDIT.\n")))
+	assert.IsFalse(t, "not generated", IsGenerated([]byte("// Code written by hand.\npackage pb\n")))
+}
@@ -1,9 +1,10 @@
 package indent
 
 import (
-	"bufio"
+	"bytes"
 	"errors"
 	"io"
+	"io/ioutil"
 
 	"github.com/daviddengcn/go-villa"
 	"github.com/daviddengcn/sgrep/parser"
@@ -22,13 +23,23 @@ const (
 )
 
 func (Parser) Parse(in io.Reader, rcvr sparser.Receiver) error {
+	src, err := ioutil.ReadAll(in)
+	if err != nil {
+		return err
+	}
+
 	lineNumber := 1
-	s := bufio.NewScanner(in)
 	var indents villa.IntSlice
 	indents.Add(-1)
-	buffers := make([][]byte, 1)
-	for ; s.Scan(); lineNumber++ {
-		line := s.Bytes()
+	for offs := 0; offs < len(src); lineNumber++ {
+		end := bytes.IndexByte(src[offs:], '\n')
+		if end < 0 {
+			end = len(src)
+		} else {
+			end += offs
+		}
+		line := bytes.TrimSuffix(src[offs:end], []byte("\r"))
+
 		indent := 0
 	lineloop:
 		for i, b := range line {
@@ -50,22 +61,19 @@ func (Parser) Parse(in io.Reader, rcvr sparser.Receiver) error {
 				}
 
 				indents.Add(indent)
-				if len(indents) > len(buffers) {
-					buffers = append(buffers, nil)
-				}
-				buffers[len(indents)-1] = append(buffers[len(indents)-1][:0], line...)
 				rg := sparser.Range{
-					MinOffs: i,
-					MaxOffs: len(line) - 1,
+					MinOffs: offs + i,
+					MaxOffs: offs + len(line) - 1,
 					MinLine: lineNumber,
 					MaxLine: lineNumber,
 				}
-				if err := rcvr.StartLevel(buffers[len(indents)-1], rg); err != nil {
+				if err := rcvr.StartLevel(src, rg); err != nil {
 					return err
 				}
 				break lineloop
 			}
 		}
+		offs = end + 1
 	}
 	for len(indents) > 1 {
 		if err := rcvr.EndLevel(nil, sparser.Range{}); err != nil {
@@ -74,5 +82,5 @@ func (Parser) Parse(in io.Reader, rcvr sparser.Receiver) error {
 		indents.Pop()
 	}
 
-	return s.Err()
+	return nil
 }
@@ -0,0 +1,148 @@
+package ini
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"strings"
+
+	"github.com/daviddengcn/sgrep/parser"
+)
+
+type Parser struct {
+	// If set, lines starting with '!' are comments too, as in .properties
+	// files.
+	BangComments bool
+}
+
+func init() {
+	factory := func() (sparser.Parser, error) {
+		return Parser{}, nil
+	}
+	for _, ext := range []string{"ini", "cfg", "conf", "gitconfig"} {
+		sparser.Register(ext, factory)
+	}
+	sparser.Register("properties", func() (sparser.Parser, error) {
+		return Parser{BangComments: true}, nil
+	})
+}
+
+// line is a line of the source.
+type line struct {
+	// offset of the line in the source
+	offs int
+	// the text without the line break
+	text []byte
+	// index of the first non-white-space byte
+	start int
+}
+
+func splitLines(src []byte) []line {
+	var lines []line
+	for offs := 0; offs < len(src); {
+		end := bytes.IndexByte(src[offs:], '\n')
+		if end < 0 {
+			end = len(src)
+		} else {
+			end += offs
+		}
+		ln := line{
+			offs: offs,
+			text: bytes.TrimSuffix(src[offs:end], []byte("\r")),
+		}
+		for ln.start < len(ln.text) && (ln.text[ln.start] == ' ' || ln.text[ln.start] == '\t') {
+			ln.start++
+		}
+		lines = append(lines, ln)
+		offs = end + 1
+	}
+	return lines
+}
+
+func (ln *line) content() []byte {
+	return ln.text[ln.start:]
+}
+
+// continued returns true if the line ends with an unescaped backslash.
+func (ln *line) continued() bool {
+	c := bytes.TrimRight(ln.content(), " \t")
+	n := 0
+	for n < len(c) && c[len(c)-1-n] == '\\' {
+		n++
+	}
+	return n%2 == 1
+}
+
+// sectionName returns the name of the section header s. A subsection, as in
+// [remote "origin"], is joined with a dot, as git does.
+func sectionName(s []byte) string {
+	s = s[1:]
+	if end := bytes.LastIndexByte(s, ']'); end >= 0 {
+		s = s[:end]
+	}
+	name := strings.TrimSpace(string(s))
+	if i := strings.IndexByte(name, '"'); i > 0 {
+		sub := strings.TrimSuffix(name[i+1:], `"`)
+		name = strings.TrimSpace(name[:i]) + "." + strings.Replace(sub, `\"`, `"`, -1)
+	}
+	return name
+}
+
+// Parse reports each section as a level, closed by the next section header,
+// and key/value pairs, including their continuation lines, and comments as
+// final blocks.
+func (p Parser) Parse(in io.Reader, rcvr sparser.Receiver) error {
+	src, err := ioutil.ReadAll(in)
+	if err != nil {
+		return err
+	}
+
+	lines := splitLines(src)
+	rangeOf := func(i, j int) sparser.Range {
+		return sparser.Range{
+			MinOffs: lines[i].offs + lines[i].start,
+			MaxOffs: lines[j].offs + len(lines[j].text) - 1,
+			MinLine: i + 1,
+			MaxLine: j + 1,
+		}
+	}
+
+	inSection := false
+	for i := 0; i < len(lines); i++ {
+		c := lines[i].content()
+		if len(c) == 0 {
+			continue
+		}
+		isComment := c[0] == ';' || c[0] == '#' || p.BangComments && c[0] == '!'
+		switch {
+		case isComment:
+			if err := rcvr.FinalBlock(src, rangeOf(i, i)); err != nil {
+				return err
+			}
+		case c[0] == '[':
+			if inSection {
+				if err := rcvr.EndLevel(src, sparser.Range{}); err != nil {
+					return err
+				}
+			}
+			node := sparser.Node{Kind: "section", Name: sectionName(c)}
+			if err := sparser.StartNode(rcvr, src, rangeOf(i, i), node); err != nil {
+				return err
+			}
+			inSection = true
+		default:
+			last := i
+			for last+1 < len(lines) && lines[last].continued() {
+				last++
+			}
+			if err := rcvr.FinalBlock(src, rangeOf(i, last)); err != nil {
+				return err
+			}
+			i = last
+		}
+	}
+	if inSection {
+		return rcvr.EndLevel(src, sparser.Range{})
+	}
+	return nil
+}
@@ -0,0 +1,97 @@
+package ini
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/daviddengcn/go-assert"
+	"github.com/daviddengcn/go-villa"
+	"github.com/daviddengcn/sgrep/parser"
+)
+
+func parse(t *testing.T, p Parser, src string) string {
+	act := ""
+	rcvr := sparser.ReceiverFunc{
+		StartLevelFunc: func(buffer []byte, header sparser.Range) error {
+			if header.IsEmpty() {
+				act += "S\n"
+				return nil
+			}
+			act += fmt.Sprintf("%d: ", header.MinLine)
+			act += "S " + string(buffer[header.MinOffs:header.MaxOffs+1]) + "\n"
+			return nil
+		},
+
+		FinalBlockFunc: func(buffer []byte, body sparser.Range) error {
+			if body.IsEmpty() {
+				return nil
+			}
+			act += fmt.Sprintf("%d: ", body.MinLine)
+			act += "F " + string(buffer[body.MinOffs:body.MaxOffs+1]) + "\n"
+			return nil
+		},
+
+		EndLevelFunc: func(buffer []byte, footer sparser.Range) error {
+			if footer.IsEmpty() {
+				act += "E\n"
+				return nil
+			}
+			act += fmt.Sprintf("%d: ", footer.MinLine)
+			act += "E " + string(buffer[footer.MinOffs:footer.MaxOffs+1]) + "\n"
+			return nil
+		},
+	}
+
+	srcBytes := villa.ByteSlice(src)
+	assert.NoError(t, p.Parse(&srcBytes, rcvr))
+	return act
+}
+
+func TestBasic(t *testing.T) {
+	src := `; global
+name = example
+
+[core]
+	editor = vim
+# remotes
+[remote "origin"]
+	url: https://example.com/repo.git
+	fetch = a \
+	  b \\
+	push = c
+`
+
+	exp := `1: F ; global
+2: F name = example
+4: S [core]
+5: F editor = vim
+6: F # remotes
+E
+7: S [remote "origin"]
+8: F url: https://example.com/repo.git
+9: F fetch = a \
+	  b \\
+11: F push = c
+E
+`
+	assert.TextEquals(t, "act", parse(t, Parser{}, src), exp)
+
+	// '!' starts a comment in .properties files only
+	p, err := sparser.New("properties")
+	assert.NoError(t, err)
+	src = "! a comment \\\nkey = value\n"
+	exp = `1: F ! a comment \
+2: F key = value
+`
+	assert.TextEquals(t, "properties", parse(t, p.(Parser), src), exp)
+	exp = `1: F ! a comment \
+key = value
+`
+	assert.TextEquals(t, "ini", parse(t, Parser{}, src), exp)
+}
+
+func TestSectionName(t *testing.T) {
+	assert.Equals(t, "section", sectionName([]byte("[core]")), "core")
+	assert.Equals(t, "subsection", sectionName([]byte(`[remote "origin"]`)), "remote.origin")
+	assert.Equals(t, "escaped", sectionName([]byte(`[a "b\"c"]`)), `a.b"c`)
+}
@@ -2,6 +2,7 @@ package json
 
 import (
 	"bytes"
+	"encoding/json"
 	"errors"
 	"fmt"
 	"io"
@@ -321,6 +322,15 @@ func makeRange(start, end scanner.Position) sparser.Range {
 	}
 }
 
+// unquote returns the value of a JSON string, or the string itself if failed.
+func unquote(s []byte) string {
+	var v string
+	if err := json.Unmarshal(s, &v); err != nil {
+		return string(s)
+	}
+	return v
+}
+
 func (Parser) Parse(in io.Reader, rcvr sparser.Receiver) error {
 	src, err := ioutil.ReadAll(in)
 	if err != nil {
@@ -333,7 +343,7 @@ func (Parser) Parse(in io.Reader, rcvr sparser.Receiver) error {
 	out := make(chan Part)
 	go parse(src, out, stop)
 
-	var keyStart scanner.Position
+	var keyStart, keyEnd scanner.Position
 
 	var types villa.IntSlice
 loop:
@@ -365,12 +375,13 @@ loop:
 
 		default: // any type that could be a value
 			var rg sparser.Range
+			var key string
 			if len(types) > 0 {
 				switch types[len(types)-1] {
 				case TP_OBJECT_START:
 					// this is the key, save the start position and wait for colon
 					// and start of value to dump together
-					keyStart = part.start
+					keyStart, keyEnd = part.start, part.end
 					break switchtp
 				case TP_ARRAY_START:
 					// an element value in an array
@@ -378,6 +389,7 @@ loop:
 				case TP_COLON:
 					// a value in an object
 					rg = makeRange(keyStart, part.end)
+					key = unquote(src[keyStart.Offset:keyEnd.Offset])
 					types[len(types)-1] = TP_OBJECT_START
 				}
 			} else {
@@ -386,7 +398,11 @@ loop:
 			}
 
 			if part.tp == TP_OBJECT_START || part.tp == TP_ARRAY_START {
-				if err := rcvr.StartLevel(src, rg); err != nil {
+				node := sparser.Node{Kind: "object", Name: key}
+				if part.tp == TP_ARRAY_START {
+					node.Kind = "array"
+				}
+				if err := sparser.StartNode(rcvr, src, rg, node); err != nil {
 					return err
 				}
 				types.Add(part.tp)
@@ -40,16 +40,55 @@ type Receiver interface {
 	FinalBlock(buffer []byte, body Range) error
 }
 
+// Node describes the syntax node of a level.
+type Node struct {
+	// Kind of the node defined by the parser, e.g. "func" for Go and
+	// "element" for XML.
+	Kind string
+	// Name of the node, e.g. the function name or the tag name. Empty if
+	// anonymous.
+	Name string
+	// The doc comment of the node, in the buffer of the header and before
+	// it. Empty if none.
+	Doc Range
+}
+
+// NodeReceiver is a Receiver which also receives the nodes of levels.
+type NodeReceiver interface {
+	Receiver
+
+	// StartNode is the same as StartLevel, with the node of the level.
+	StartNode(buffer []byte, header Range, node Node) error
+}
+
+// StartNode starts a level of node. StartLevel is called instead if rcvr is
+// not a NodeReceiver.
+func StartNode(rcvr Receiver, buffer []byte, header Range, node Node) error {
+	if nrcvr, ok := rcvr.(NodeReceiver); ok {
+		return nrcvr.StartNode(buffer, header, node)
+	}
+	return rcvr.StartLevel(buffer, header)
+}
+
 type ReceiverFunc struct {
 	StartLevelFunc func(buffer []byte, header Range) error
 	EndLevelFunc   func(buffer []byte, footer Range) error
 	FinalBlockFunc func(buffer []byte, body Range) error
+	// Optional. StartLevelFunc is called for StartNode if not set.
+	StartNodeFunc func(buffer []byte, header Range, node Node) error
 }
 
 func (rcvr ReceiverFunc) StartLevel(buffer []byte, header Range) error {
 	return rcvr.StartLevelFunc(buffer, header)
 }
 
+func (rcvr ReceiverFunc) StartNode(buffer []byte, header Range, node Node) error {
+	if rcvr.StartNodeFunc == nil {
+		return rcvr.StartLevelFunc(buffer, header)
+	}
+	return rcvr.StartNodeFunc(buffer, header, node)
+}
+
 func (rcvr ReceiverFunc) EndLevel(buffer []byte, footer Range) error {
 	return rcvr.EndLevelFunc(buffer, footer)
 }
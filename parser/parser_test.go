@@ -35,3 +35,21 @@ func TestRegister(t *testing.T) {
 	assert.Equals(t, "ps", ps, nil)
 	assert.Equals(t, "err", err.Deepest(), myErr)
 }
+
+func TestStartNode(t *testing.T) {
+	var act []string
+	rcvr := ReceiverFunc{
+		StartLevelFunc: func(buffer []byte, header Range) error {
+			act = append(act, "level")
+			return nil
+		},
+	}
+	assert.NoError(t, StartNode(rcvr, nil, Range{}, Node{Kind: "func", Name: "f"}))
+
+	rcvr.StartNodeFunc = func(buffer []byte, header Range, node Node) error {
+		act = append(act, node.Kind+" "+node.Name)
+		return nil
+	}
+	assert.NoError(t, StartNode(rcvr, nil, Range{}, Node{Kind: "func", Name: "f"}))
+	assert.StringEquals(t, "act", act, []string{"level", "func f"})
+}
@@ -0,0 +1,352 @@
+package python
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"sort"
+
+	"github.com/daviddengcn/sgrep/parser"
+)
+
+type Parser struct{}
+
+func init() {
+	sparser.Register("py", func() (sparser.Parser, error) {
+		return Parser{}, nil
+	})
+}
+
+// stmt is a logical line, which could span several physical lines because of
+// brackets, backslash continuations or triple-quoted strings.
+type stmt struct {
+	// [start, end) of the code, or of the comment for a comment line
+	start, end int
+	// [start, end) of the trailing comment, if any
+	commentStart, commentEnd int
+	// number of leading white spaces of the first line, tabs expanded
+	indent    int
+	isComment bool
+}
+
+func (s *stmt) hasComment() bool {
+	return s.commentEnd > s.commentStart
+}
+
+// stringEnd returns the end of the string starting at pos.
+func stringEnd(src []byte, pos int) int {
+	quote := src[pos]
+	if bytes.HasPrefix(src[pos:], []byte{quote, quote, quote}) {
+		for i := pos + 3; i < len(src); i++ {
+			if src[i] == '\\' {
+				i++
+			} else if bytes.HasPrefix(src[i:], []byte{quote, quote, quote}) {
+				return i + 3
+			}
+		}
+		return len(src)
+	}
+	for i := pos + 1; i < len(src); i++ {
+		switch src[i] {
+		case '\\':
+			i++
+		case quote:
+			return i + 1
+		case '\n':
+			// unterminated
+			return i
+		}
+	}
+	return len(src)
+}
+
+func lineEnd(src []byte, pos int) int {
+	end := bytes.IndexByte(src[pos:], '\n')
+	if end < 0 {
+		return len(src)
+	}
+	end += pos
+	if end > pos && src[end-1] == '\r' {
+		end--
+	}
+	return end
+}
+
+// splitStmts splits src into logical lines, skipping blank lines.
+func splitStmts(src []byte) []stmt {
+	var stmts []stmt
+	for pos := 0; pos < len(src); {
+		s := stmt{}
+		for ; pos < len(src); pos++ {
+			if b := src[pos]; b == ' ' {
+				s.indent++
+			} else if b == '\t' {
+				s.indent += 8 - s.indent%8
+			} else if b == '\f' {
+				s.indent = 0
+			} else {
+				break
+			}
+		}
+		if pos >= len(src) {
+			break
+		}
+		switch src[pos] {
+		case '\r', '\n':
+			// a blank line
+			pos++
+			continue
+		case '#':
+			s.start, s.end, s.isComment = pos, lineEnd(src, pos), true
+			stmts = append(stmts, s)
+			pos = s.end
+			continue
+		}
+
+		s.start, s.end = pos, pos+1
+		depth := 0
+	scan:
+		for pos < len(src) {
+			b := src[pos]
+			switch b {
+			case '"', '\'':
+				pos = stringEnd(src, pos)
+				s.end = pos
+				continue
+			case '#':
+				end := lineEnd(src, pos)
+				if depth == 0 {
+					s.commentStart, s.commentEnd = pos, end
+					pos = end
+					break scan
+				}
+				pos = end
+				continue
+			case '\\':
+				if pos++; pos < len(src) && src[pos] == '\r' {
+					pos++
+				}
+				pos++
+				continue
+			case '\n':
+				if depth == 0 {
+					break scan
+				}
+			case '(', '[', '{':
+				depth++
+			case ')', ']', '}':
+				if depth > 0 {
+					depth--
+				}
+			}
+			if b != ' ' && b != '\t' && b != '\r' && b != '\n' {
+				s.end = pos + 1
+			}
+			pos++
+		}
+		stmts = append(stmts, s)
+	}
+	return stmts
+}
+
+func isIdentByte(b byte) bool {
+	return b == '_' || b >= 'a' && b <= 'z' || b >= 'A' && b <= 'Z' || b >= '0' && b <= '9' || b >= 0x80
+}
+
+// firstWords returns the leading identifier of s and the one after it.
+func firstWords(s []byte) (first, second string) {
+	word := func(s []byte) (string, []byte) {
+		s = bytes.TrimLeft(s, " \t\\\r\n")
+		i := 0
+		for i < len(s) && isIdentByte(s[i]) {
+			i++
+		}
+		return string(s[:i]), s[i:]
+	}
+	first, s = word(s)
+	second, _ = word(s)
+	return first, second
+}
+
+var compoundKeywords = map[string]bool{
+	"if": true, "elif": true, "else": true, "for": true, "while": true,
+	"with": true, "try": true, "except": true, "finally": true,
+	"match": true, "case": true,
+}
+
+// nodeOf returns the node of a compound statement.
+func nodeOf(header []byte) sparser.Node {
+	first, second := firstWords(header)
+	if first == "async" {
+		header = bytes.TrimPrefix(bytes.TrimSpace(header), []byte("async"))
+		first, second = firstWords(header)
+	}
+	switch {
+	case first == "def" || first == "class":
+		return sparser.Node{Kind: first, Name: second}
+	case compoundKeywords[first]:
+		return sparser.Node{Kind: first}
+	}
+	return sparser.Node{Kind: "block"}
+}
+
+type parser struct {
+	src  []byte
+	rcvr sparser.Receiver
+	// offsets of the starts of lines
+	lineStarts []int
+	stmts      []stmt
+	// indents of the open levels
+	levels []int
+	// decorators, and comments among them, before a definition
+	pending []*stmt
+}
+
+func (p *parser) lineOf(offs int) int {
+	return sort.SearchInts(p.lineStarts, offs+1)
+}
+
+// rangeOf returns the range of src[start:end].
+func (p *parser) rangeOf(start, end int) sparser.Range {
+	return sparser.Range{
+		MinOffs: start,
+		MaxOffs: end - 1,
+		MinLine: p.lineOf(start),
+		MaxLine: p.lineOf(end - 1),
+	}
+}
+
+// nextCode returns the index of the first logical line of code after i, or
+// len(stmts).
+func (p *parser) nextCode(i int) int {
+	for i++; i < len(p.stmts) && p.stmts[i].isComment; i++ {
+	}
+	return i
+}
+
+// closeLevels closes the levels which a line of code at indent is not inside.
+func (p *parser) closeLevels(indent int) error {
+	for len(p.levels) > 0 && p.levels[len(p.levels)-1] >= indent {
+		if err := p.rcvr.EndLevel(p.src, sparser.Range{}); err != nil {
+			return err
+		}
+		p.levels = p.levels[:len(p.levels)-1]
+	}
+	return nil
+}
+
+// finalBlock sends a logical line, with its trailing comment, as final blocks.
+func (p *parser) finalBlock(s *stmt) error {
+	if err := p.rcvr.FinalBlock(p.src, p.rangeOf(s.start, s.end)); err != nil {
+		return err
+	}
+	if s.hasComment() {
+		return p.rcvr.FinalBlock(p.src, p.rangeOf(s.commentStart, s.commentEnd))
+	}
+	return nil
+}
+
+func (p *parser) flushPending() error {
+	for _, s := range p.pending {
+		if err := p.finalBlock(s); err != nil {
+			return err
+		}
+	}
+	p.pending = nil
+	return nil
+}
+
+func (p *parser) parse() error {
+	for i := range p.stmts {
+		s := &p.stmts[i]
+		next := p.nextCode(i)
+		if s.isComment {
+			if len(p.pending) > 0 {
+				p.pending = append(p.pending, s)
+				continue
+			}
+			// a comment is outside the levels at its indent or deeper, but
+			// inside a block continued by a more indented line
+			indent := s.indent
+			if next < len(p.stmts) && p.stmts[next].indent > indent {
+				indent = p.stmts[next].indent
+			}
+			if err := p.closeLevels(indent); err != nil {
+				return err
+			}
+			if err := p.finalBlock(s); err != nil {
+				return err
+			}
+			continue
+		}
+
+		if len(p.pending) == 0 {
+			if err := p.closeLevels(s.indent); err != nil {
+				return err
+			}
+		}
+		if p.src[s.start] == '@' {
+			p.pending = append(p.pending, s)
+			continue
+		}
+
+		isHeader := p.src[s.end-1] == ':' && next < len(p.stmts) && p.stmts[next].indent > s.indent
+		if !isHeader {
+			if err := p.flushPending(); err != nil {
+				return err
+			}
+			if err := p.finalBlock(s); err != nil {
+				return err
+			}
+			continue
+		}
+
+		node := nodeOf(p.src[s.start:s.end])
+		start := s.start
+		if len(p.pending) > 0 {
+			if first, _ := firstWords(p.src[s.start:s.end]); first == "def" || first == "class" || first == "async" {
+				start = p.pending[0].start
+				p.pending = nil
+			} else if err := p.flushPending(); err != nil {
+				return err
+			}
+		}
+		if err := sparser.StartNode(p.rcvr, p.src, p.rangeOf(start, s.end), node); err != nil {
+			return err
+		}
+		if s.hasComment() {
+			if err := p.rcvr.FinalBlock(p.src, p.rangeOf(s.commentStart, s.commentEnd)); err != nil {
+				return err
+			}
+		}
+		p.levels = append(p.levels, s.indent)
+	}
+
+	if err := p.flushPending(); err != nil {
+		return err
+	}
+	return p.closeLevels(0)
+}
+
+// Parse reports compound statements, whose headers include their decorators
+// and continuation lines, as levels, and other logical lines and comments as
+// final blocks.
+func (Parser) Parse(in io.Reader, rcvr sparser.Receiver) error {
+	src, err := ioutil.ReadAll(in)
+	if err != nil {
+		return err
+	}
+
+	p := &parser{
+		src:        src,
+		rcvr:       rcvr,
+		lineStarts: []int{0},
+		stmts:      splitStmts(src),
+	}
+	for i, b := range src {
+		if b == '\n' {
+			p.lineStarts = append(p.lineStarts, i+1)
+		}
+	}
+	return p.parse()
+}
@@ -0,0 +1,147 @@
+package python
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/daviddengcn/go-assert"
+	"github.com/daviddengcn/go-villa"
+	"github.com/daviddengcn/sgrep/parser"
+)
+
+func parse(t *testing.T, src string) string {
+	act := ""
+	rcvr := sparser.ReceiverFunc{
+		StartLevelFunc: func(buffer []byte, header sparser.Range) error {
+			if header.IsEmpty() {
+				act += "S\n"
+				return nil
+			}
+			act += fmt.Sprintf("%d: ", header.MinLine)
+			act += "S " + string(buffer[header.MinOffs:header.MaxOffs+1]) + "\n"
+			return nil
+		},
+
+		FinalBlockFunc: func(buffer []byte, body sparser.Range) error {
+			if body.IsEmpty() {
+				return nil
+			}
+			act += fmt.Sprintf("%d: ", body.MinLine)
+			act += "F " + string(buffer[body.MinOffs:body.MaxOffs+1]) + "\n"
+			return nil
+		},
+
+		EndLevelFunc: func(buffer []byte, footer sparser.Range) error {
+			if footer.IsEmpty() {
+				act += "E\n"
+				return nil
+			}
+			act += fmt.Sprintf("%d: ", footer.MinLine)
+			act += "E " + string(buffer[footer.MinOffs:footer.MaxOffs+1]) + "\n"
+			return nil
+		},
+	}
+
+	srcBytes := villa.ByteSlice(src)
+	assert.NoError(t, Parser{}.Parse(&srcBytes, rcvr))
+	return act
+}
+
+func TestBasic(t *testing.T) {
+	src := `import os
+
+
+@app.route("/",
+           methods=["GET"])
+# the index
+@login_required
+def index(request,
+          user=None):  # noqa
+    """Shows the index.
+
+Body lines at any indentation.
+    """
+    x = (1 +
+2)
+    if x > \
+            1:
+        return os.path.join("a",
+  "b")
+# dedented comment
+    return None
+
+class A(object):
+    pass
+    # inside A
+# outside A
+async def f(): return 1
+`
+	exp := `1: F import os
+4: S @app.route("/",
+           methods=["GET"])
+# the index
+@login_required
+def index(request,
+          user=None):
+9: F # noqa
+10: F """Shows the index.
+
+Body lines at any indentation.
+    """
+14: F x = (1 +
+2)
+16: S if x > \
+            1:
+18: F return os.path.join("a",
+  "b")
+E
+20: F # dedented comment
+21: F return None
+E
+23: S class A(object):
+24: F pass
+25: F # inside A
+E
+26: F # outside A
+27: F async def f(): return 1
+`
+	assert.TextEquals(t, "act", parse(t, src), exp)
+}
+
+func TestCommentAtDedent(t *testing.T) {
+	src := `def f():
+    if x:
+        a()
+    # note
+# commented out
+    b()
+    if y:
+        c()
+    # trailing
+d()
+`
+
+	exp := `1: S def f():
+2: S if x:
+3: F a()
+E
+4: F # note
+5: F # commented out
+6: F b()
+7: S if y:
+8: F c()
+E
+9: F # trailing
+E
+10: F d()
+`
+	assert.TextEquals(t, "act", parse(t, src), exp)
+}
+
+func TestNodeOf(t *testing.T) {
+	assert.StringEquals(t, "def", nodeOf([]byte("def f(x):")), sparser.Node{Kind: "def", Name: "f"})
+	assert.StringEquals(t, "async def", nodeOf([]byte("async def f(x):")), sparser.Node{Kind: "def", Name: "f"})
+	assert.StringEquals(t, "class", nodeOf([]byte("class A(B):")), sparser.Node{Kind: "class", Name: "A"})
+	assert.StringEquals(t, "if", nodeOf([]byte("if x:")), sparser.Node{Kind: "if"})
+	assert.StringEquals(t, "other", nodeOf([]byte("x = {1:")), sparser.Node{Kind: "block"})
+}
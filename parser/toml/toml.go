@@ -0,0 +1,308 @@
+package toml
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"sort"
+	"strings"
+
+	"github.com/daviddengcn/sgrep/parser"
+)
+
+type Parser struct{}
+
+func init() {
+	sparser.Register("toml", func() (sparser.Parser, error) {
+		return Parser{}, nil
+	})
+}
+
+type parser struct {
+	src  []byte
+	rcvr sparser.Receiver
+	// offsets of the starts of lines
+	lineStarts []int
+	pos        int
+}
+
+func (p *parser) lineOf(offs int) int {
+	return sort.SearchInts(p.lineStarts, offs+1)
+}
+
+// rangeOf returns the range of src[start:end].
+func (p *parser) rangeOf(start, end int) sparser.Range {
+	return sparser.Range{
+		MinOffs: start,
+		MaxOffs: end - 1,
+		MinLine: p.lineOf(start),
+		MaxLine: p.lineOf(end - 1),
+	}
+}
+
+func (p *parser) skipSpaces() {
+	for p.pos < len(p.src) && (p.src[p.pos] == ' ' || p.src[p.pos] == '\t') {
+		p.pos++
+	}
+}
+
+// lineEnd returns the offset of the end of the line at pos, excluding the
+// line break.
+func (p *parser) lineEnd(pos int) int {
+	end := bytes.IndexByte(p.src[pos:], '\n')
+	if end < 0 {
+		end = len(p.src)
+	} else {
+		end += pos
+	}
+	if end > pos && p.src[end-1] == '\r' {
+		end--
+	}
+	return end
+}
+
+// skipBlank skips white spaces, line breaks and comments. Comments are sent as
+// final blocks.
+func (p *parser) skipBlank() error {
+	for p.pos < len(p.src) {
+		switch p.src[p.pos] {
+		case ' ', '\t', '\r', '\n':
+			p.pos++
+		case '#':
+			end := p.lineEnd(p.pos)
+			if err := p.rcvr.FinalBlock(p.src, p.rangeOf(p.pos, end)); err != nil {
+				return err
+			}
+			p.pos = end
+		default:
+			return nil
+		}
+	}
+	return nil
+}
+
+// stringEnd returns the end of the string starting at pos, which could be a
+// basic, literal or multi-line string.
+func (p *parser) stringEnd(pos int) int {
+	quote := p.src[pos]
+	if bytes.HasPrefix(p.src[pos:], []byte{quote, quote, quote}) {
+		delim := []byte{quote, quote, quote}
+		end := bytes.Index(p.src[pos+3:], delim)
+		if end < 0 {
+			return len(p.src)
+		}
+		end += pos + 6
+		// up to two more quotes could be in the string
+		for i := 0; i < 2 && end < len(p.src) && p.src[end] == quote; i++ {
+			end++
+		}
+		return end
+	}
+	for i := pos + 1; i < len(p.src); i++ {
+		switch p.src[i] {
+		case '\\':
+			if quote == '"' {
+				i++
+			}
+		case quote:
+			return i + 1
+		case '\n':
+			// unterminated
+			return i
+		}
+	}
+	return len(p.src)
+}
+
+// valueEnd returns the end of the value starting at pos without parsing it.
+func (p *parser) valueEnd(pos int) int {
+	switch p.src[pos] {
+	case '"', '\'':
+		return p.stringEnd(pos)
+	case '[', '{':
+		depth := 0
+		for i := pos; i < len(p.src); i++ {
+			switch p.src[i] {
+			case '"', '\'':
+				i = p.stringEnd(i) - 1
+			case '#':
+				i = p.lineEnd(i) - 1
+			case '[', '{':
+				depth++
+			case ']', '}':
+				if depth--; depth == 0 {
+					return i + 1
+				}
+			}
+		}
+		return len(p.src)
+	}
+	end := pos
+	for end < len(p.src) && !strings.ContainsRune(",]}#\r\n", rune(p.src[end])) {
+		end++
+	}
+	for end > pos+1 && (p.src[end-1] == ' ' || p.src[end-1] == '\t') {
+		end--
+	}
+	return end
+}
+
+// value sends the value at pos. start is the start of the key, or of the
+// value for an element of an array. Inline tables and multi-line arrays are
+// sent as levels, other values as final blocks from start.
+func (p *parser) value(start int, name string) error {
+	if p.pos >= len(p.src) {
+		return nil
+	}
+	end := p.valueEnd(p.pos)
+	switch open := p.src[p.pos]; {
+	case open == '{' || open == '[' && p.lineOf(p.pos) != p.lineOf(end-1):
+		close := byte('}')
+		node := sparser.Node{Kind: "inline-table", Name: name}
+		if open == '[' {
+			close, node.Kind = ']', "array"
+		}
+		if err := sparser.StartNode(p.rcvr, p.src, p.rangeOf(start, p.pos+1), node); err != nil {
+			return err
+		}
+		for p.pos++; ; {
+			if err := p.skipBlank(); err != nil {
+				return err
+			}
+			if p.pos >= len(p.src) {
+				return p.rcvr.EndLevel(p.src, sparser.Range{})
+			}
+			switch p.src[p.pos] {
+			case close:
+				p.pos++
+				return p.rcvr.EndLevel(p.src, p.rangeOf(p.pos-1, p.pos))
+			case ',':
+				p.pos++
+				continue
+			}
+			var err error
+			switch {
+			case open == '{':
+				err = p.keyValue()
+			case p.valueEnd(p.pos) == p.pos:
+				// an unexpected byte, e.g. a '}' in an array, is skipped
+				err = p.rcvr.FinalBlock(p.src, p.rangeOf(p.pos, p.pos+1))
+				p.pos++
+			default:
+				err = p.value(p.pos, "")
+			}
+			if err != nil {
+				return err
+			}
+		}
+	}
+	p.pos = end
+	return p.rcvr.FinalBlock(p.src, p.rangeOf(start, end))
+}
+
+// keyValue sends the key/value pair at pos.
+func (p *parser) keyValue() error {
+	start := p.pos
+	for p.pos < len(p.src) && p.src[p.pos] != '=' {
+		switch p.src[p.pos] {
+		case '"', '\'':
+			p.pos = p.stringEnd(p.pos)
+			continue
+		case '\r', '\n', ',', '}':
+			// malformed, sent as a final block
+			if p.pos == start {
+				p.pos++
+			}
+			return p.rcvr.FinalBlock(p.src, p.rangeOf(start, p.pos))
+		}
+		p.pos++
+	}
+	if p.pos >= len(p.src) {
+		return p.rcvr.FinalBlock(p.src, p.rangeOf(start, p.pos))
+	}
+	name := strings.TrimSpace(string(p.src[start:p.pos]))
+	p.pos++
+	p.skipSpaces()
+	return p.value(start, name)
+}
+
+// tableHeader returns the end of the header of a table, or an array of tables,
+// at pos, and its name.
+func (p *parser) tableHeader(pos int) (end int, name string, isArray bool) {
+	isArray = bytes.HasPrefix(p.src[pos:], []byte("[["))
+	nameStart := pos + 1
+	if isArray {
+		nameStart++
+	}
+	lineEnd := p.lineEnd(pos)
+	for end = nameStart; end < lineEnd && p.src[end] != ']'; end++ {
+		if p.src[end] == '"' || p.src[end] == '\'' {
+			end = p.stringEnd(end) - 1
+		}
+	}
+	name = strings.TrimSpace(string(p.src[nameStart:end]))
+	if end < lineEnd {
+		end++
+		if isArray && end < lineEnd && p.src[end] == ']' {
+			end++
+		}
+	}
+	return end, name, isArray
+}
+
+// Parse reports tables and arrays of tables as levels closed by the next
+// table header, and key/value pairs as final blocks. Inline tables and
+// multi-line arrays are nested levels.
+func (Parser) Parse(in io.Reader, rcvr sparser.Receiver) error {
+	src, err := ioutil.ReadAll(in)
+	if err != nil {
+		return err
+	}
+
+	p := &parser{
+		src:        src,
+		rcvr:       rcvr,
+		lineStarts: []int{0},
+	}
+	for i, b := range src {
+		if b == '\n' {
+			p.lineStarts = append(p.lineStarts, i+1)
+		}
+	}
+
+	inTable := false
+	for {
+		if err := p.skipBlank(); err != nil {
+			return err
+		}
+		if p.pos >= len(src) {
+			break
+		}
+		if src[p.pos] != '[' {
+			if err := p.keyValue(); err != nil {
+				return err
+			}
+			continue
+		}
+
+		if inTable {
+			if err := rcvr.EndLevel(src, sparser.Range{}); err != nil {
+				return err
+			}
+		}
+		end, name, isArray := p.tableHeader(p.pos)
+		node := sparser.Node{Kind: "table", Name: name}
+		if isArray {
+			node.Kind = "array-table"
+		}
+		if err := sparser.StartNode(rcvr, src, p.rangeOf(p.pos, end), node); err != nil {
+			return err
+		}
+		inTable = true
+		p.pos = end
+	}
+	if inTable {
+		return rcvr.EndLevel(src, sparser.Range{})
+	}
+	return nil
+}
@@ -0,0 +1,141 @@
+package toml
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/daviddengcn/go-assert"
+	"github.com/daviddengcn/go-villa"
+	"github.com/daviddengcn/sgrep/parser"
+)
+
+func parse(t *testing.T, src string) string {
+	act := ""
+	rcvr := sparser.ReceiverFunc{
+		StartLevelFunc: func(buffer []byte, header sparser.Range) error {
+			if header.IsEmpty() {
+				act += "S\n"
+				return nil
+			}
+			act += fmt.Sprintf("%d: ", header.MinLine)
+			act += "S " + string(buffer[header.MinOffs:header.MaxOffs+1]) + "\n"
+			return nil
+		},
+
+		FinalBlockFunc: func(buffer []byte, body sparser.Range) error {
+			if body.IsEmpty() {
+				return nil
+			}
+			act += fmt.Sprintf("%d: ", body.MinLine)
+			act += "F " + string(buffer[body.MinOffs:body.MaxOffs+1]) + "\n"
+			return nil
+		},
+
+		EndLevelFunc: func(buffer []byte, footer sparser.Range) error {
+			if footer.IsEmpty() {
+				act += "E\n"
+				return nil
+			}
+			act += fmt.Sprintf("%d: ", footer.MinLine)
+			act += "E " + string(buffer[footer.MinOffs:footer.MaxOffs+1]) + "\n"
+			return nil
+		},
+	}
+
+	srcBytes := villa.ByteSlice(src)
+	assert.NoError(t, Parser{}.Parse(&srcBytes, rcvr))
+	return act
+}
+
+func TestBasic(t *testing.T) {
+	src := `# comment
+title = "example"
+
+[server]
+host = "localhost" # trailing
+ports = [ 8000, 8001 ]
+limits = { cpu = 2, mem = "1G" }
+
+[[products]]
+name = """
+multi
+line"""
+tags = [
+  "a",
+  { b = 1 },
+]
+`
+
+	exp := `1: F # comment
+2: F title = "example"
+4: S [server]
+5: F host = "localhost"
+5: F # trailing
+6: F ports = [ 8000, 8001 ]
+7: S limits = {
+7: F cpu = 2
+7: F mem = "1G"
+7: E }
+E
+9: S [[products]]
+10: F name = """
+multi
+line"""
+13: S tags = [
+14: F "a"
+15: S {
+15: F b = 1
+15: E }
+16: E ]
+E
+`
+	assert.TextEquals(t, "act", parse(t, src), exp)
+}
+
+// parseFinishing is parse failing the test if parsing does not finish.
+func parseFinishing(t *testing.T, src string) string {
+	done := make(chan string, 1)
+	go func() {
+		done <- parse(t, src)
+	}()
+	select {
+	case act := <-done:
+		return act
+	case <-time.After(5 * time.Second):
+		t.Fatalf("parsing %q does not finish", src)
+	}
+	return ""
+}
+
+func TestMalformed(t *testing.T) {
+	src := `a = [
+  1,
+  }
+]
+`
+	exp := `1: S a = [
+2: F 1
+3: F }
+4: E ]
+`
+	assert.TextEquals(t, "act", parseFinishing(t, src), exp)
+}
+
+// TestTruncated checks that parsing finishes on every prefix of a source,
+// followed by unbalanced closing brackets.
+func TestTruncated(t *testing.T) {
+	src := `[a]
+b = [ 1, { c = "x" }, ]
+d = { e = [
+  2, ] }
+f = """
+g"""
+[[h]]
+i = ' ]}
+`
+	for i := 0; i <= len(src); i++ {
+		parseFinishing(t, src[:i])
+		parseFinishing(t, src[:i]+"}]")
+	}
+}
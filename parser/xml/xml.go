@@ -236,7 +236,7 @@ func (Parser) Parse(in io.Reader, rcvr sparser.Receiver) error {
 			}
 		case TP_START:
 			if len(name) > 0 {
-				if err := rcvr.StartLevel(src, rg); err != nil {
+				if err := sparser.StartNode(rcvr, src, rg, sparser.Node{Kind: "element", Name: name}); err != nil {
 					return err
 				}
 				stack.Add(name)
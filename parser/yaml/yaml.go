@@ -0,0 +1,431 @@
+package yaml
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"regexp"
+	"strings"
+
+	"github.com/daviddengcn/sgrep/parser"
+)
+
+type Parser struct{}
+
+func init() {
+	factory := func() (sparser.Parser, error) {
+		return Parser{}, nil
+	}
+	sparser.Register("yaml", factory)
+	sparser.Register("yml", factory)
+}
+
+// line is a line of the source.
+type line struct {
+	// offset of the line in the source
+	offs int
+	// the text without the line break
+	text []byte
+	// number of leading white spaces, tabs expanded
+	indent int
+	// index of the first non-white-space byte
+	start int
+	blank bool
+}
+
+func splitLines(src []byte) []line {
+	var lines []line
+	for offs := 0; offs < len(src); {
+		end := bytes.IndexByte(src[offs:], '\n')
+		if end < 0 {
+			end = len(src)
+		} else {
+			end += offs
+		}
+		ln := line{
+			offs: offs,
+			text: bytes.TrimSuffix(src[offs:end], []byte("\r")),
+		}
+		for ln.start = 0; ln.start < len(ln.text); ln.start++ {
+			if b := ln.text[ln.start]; b == ' ' {
+				ln.indent++
+			} else if b == '\t' {
+				ln.indent += 8 - ln.indent%8
+			} else {
+				break
+			}
+		}
+		ln.blank = ln.start == len(ln.text)
+		lines = append(lines, ln)
+		offs = end + 1
+	}
+	return lines
+}
+
+func (ln *line) content() []byte {
+	return ln.text[ln.start:]
+}
+
+func (ln *line) isComment() bool {
+	return !ln.blank && ln.text[ln.start] == '#'
+}
+
+// isMarker returns true if the line is the document marker, i.e. "---" or
+// "...", optionally followed by content.
+func (ln *line) isMarker(marker string) bool {
+	return bytes.HasPrefix(ln.text, []byte(marker)) &&
+		(len(ln.text) == 3 || ln.text[3] == ' ' || ln.text[3] == '\t')
+}
+
+func (ln *line) isItem() bool {
+	c := ln.content()
+	return len(c) > 0 && c[0] == '-' && (len(c) == 1 || c[1] == ' ' || c[1] == '\t')
+}
+
+// uncomment returns s with the trailing comment and white spaces removed.
+func uncomment(s []byte) []byte {
+	var quote byte
+	for i, b := range s {
+		switch {
+		case quote != 0:
+			if b == quote {
+				quote = 0
+			}
+		case b == '"' || b == '\'':
+			quote = b
+		case b == '#' && (i == 0 || s[i-1] == ' ' || s[i-1] == '\t'):
+			return bytes.TrimRight(s[:i], " \t")
+		}
+	}
+	return bytes.TrimRight(s, " \t")
+}
+
+// splitKey splits an entry of a mapping into the key and the value. ok is
+// false if s is not an entry.
+func splitKey(s []byte) (key string, value []byte, ok bool) {
+	if len(s) > 0 && (s[0] == '{' || s[0] == '[') {
+		// a flow collection
+		return "", nil, false
+	}
+	var quote byte
+	for i, b := range s {
+		switch {
+		case quote != 0:
+			if b == quote {
+				quote = 0
+			}
+		case b == '"' || b == '\'':
+			quote = b
+		case b == ':' && (i+1 == len(s) || s[i+1] == ' ' || s[i+1] == '\t'):
+			key = strings.TrimSpace(string(s[:i]))
+			if len(key) >= 2 && (key[0] == '"' || key[0] == '\'') && key[len(key)-1] == key[0] {
+				key = key[1 : len(key)-1]
+			}
+			return key, bytes.TrimSpace(s[i+1:]), true
+		case b == '#' && (i == 0 || s[i-1] == ' '):
+			return "", nil, false
+		}
+	}
+	return "", nil, false
+}
+
+// flowDepth returns the number of unclosed brackets of flow collections in s.
+func flowDepth(s []byte) int {
+	depth := 0
+	var quote byte
+	for _, b := range s {
+		switch {
+		case quote != 0:
+			if b == quote {
+				quote = 0
+			}
+		case b == '"' || b == '\'':
+			quote = b
+		case b == '{' || b == '[':
+			depth++
+		case b == '}' || b == ']':
+			depth--
+		}
+	}
+	return depth
+}
+
+var blockScalarRe = regexp.MustCompile(`^[|>][-+0-9]*$`)
+
+// level is an open level of a mapping or a sequence.
+type level struct {
+	indent int
+	// items of a sequence at the same indent of the key are inside.
+	seqAtIndent bool
+}
+
+type parser struct {
+	src   []byte
+	rcvr  sparser.Receiver
+	lines []line
+	// open levels in the current document
+	levels []level
+}
+
+// rangeOf returns the range from the content of lines[i] to the end of
+// lines[j].
+func (p *parser) rangeOf(i, j int) sparser.Range {
+	return sparser.Range{
+		MinOffs: p.lines[i].offs + p.lines[i].start,
+		MaxOffs: p.lines[j].offs + len(p.lines[j].text) - 1,
+		MinLine: i + 1,
+		MaxLine: j + 1,
+	}
+}
+
+// next returns the index of the next line of content after i, or len(lines).
+func (p *parser) next(i int) int {
+	for i++; i < len(p.lines); i++ {
+		if !p.lines[i].blank && !p.lines[i].isComment() {
+			break
+		}
+	}
+	return i
+}
+
+// inside returns the index of the last line after i which is blank or more
+// indented than indent.
+func (p *parser) inside(i, indent int) int {
+	last := i
+	for j := i + 1; j < len(p.lines); j++ {
+		if p.lines[j].blank {
+			continue
+		}
+		if p.lines[j].indent <= indent || p.lines[j].isMarker("---") || p.lines[j].isMarker("...") {
+			break
+		}
+		last = j
+	}
+	return last
+}
+
+// closeLevels closes the levels which the line of content ln is not inside.
+func (p *parser) closeLevels(ln *line) error {
+	for len(p.levels) > 0 {
+		top := p.levels[len(p.levels)-1]
+		if ln.indent > top.indent || top.seqAtIndent && ln.indent == top.indent && ln.isItem() {
+			break
+		}
+		if err := p.rcvr.EndLevel(p.src, sparser.Range{}); err != nil {
+			return err
+		}
+		p.levels = p.levels[:len(p.levels)-1]
+	}
+	return nil
+}
+
+func (p *parser) closeDocument(footer sparser.Range) error {
+	for ; len(p.levels) > 0; p.levels = p.levels[:len(p.levels)-1] {
+		if err := p.rcvr.EndLevel(p.src, sparser.Range{}); err != nil {
+			return err
+		}
+	}
+	return p.rcvr.EndLevel(p.src, footer)
+}
+
+// flow sends a multi-line flow collection starting at lines[i] as a level
+// with header. Returns the index of the last line.
+func (p *parser) flow(i int, key string, header sparser.Range) (int, error) {
+	if err := sparser.StartNode(p.rcvr, p.src, header, sparser.Node{Kind: "flow", Name: key}); err != nil {
+		return i, err
+	}
+	depth := flowDepth(uncomment(p.lines[i].content()))
+	for j := i + 1; j < len(p.lines); j++ {
+		ln := &p.lines[j]
+		if ln.blank {
+			continue
+		}
+		if !ln.isComment() {
+			depth += flowDepth(uncomment(ln.content()))
+		}
+		if depth <= 0 {
+			return j, p.rcvr.EndLevel(p.src, p.rangeOf(j, j))
+		}
+		if err := p.rcvr.FinalBlock(p.src, p.rangeOf(j, j)); err != nil {
+			return j, err
+		}
+	}
+	return len(p.lines) - 1, p.rcvr.EndLevel(p.src, sparser.Range{})
+}
+
+// entry sends the entry of a mapping or an item of a sequence at lines[i].
+// Returns the index of its last line.
+func (p *parser) entry(i int) (int, error) {
+	ln := &p.lines[i]
+	c := ln.content()
+	isItem := ln.isItem()
+	if isItem {
+		c = bytes.TrimLeft(c[1:], " \t")
+	}
+	key, value, isEntry := splitKey(c)
+	if !isEntry {
+		value = c
+	}
+	value = uncomment(value)
+
+	if next := p.next(i); isItem && isEntry && next < len(p.lines) && p.lines[next].indent > ln.indent &&
+		!p.lines[next].isMarker("---") && !p.lines[next].isMarker("...") {
+		// the entries are at the column after "- "
+		return p.itemMapping(i, key, value, ln.indent+len(ln.content())-len(c))
+	}
+
+	if blockScalarRe.Match(value) {
+		// the block scalar is the more indented lines
+		last := p.inside(i, ln.indent)
+		return last, p.rcvr.FinalBlock(p.src, p.rangeOf(i, last))
+	}
+	if len(value) > 0 && (value[0] == '{' || value[0] == '[') && flowDepth(value) > 0 {
+		return p.flow(i, key, p.rangeOf(i, i))
+	}
+
+	next := p.next(i)
+	if next == len(p.lines) || p.lines[next].isMarker("---") || p.lines[next].isMarker("...") {
+		return i, p.rcvr.FinalBlock(p.src, p.rangeOf(i, i))
+	}
+	nextLn := &p.lines[next]
+	if len(value) > 0 && (!isItem || !isEntry) {
+		// a scalar, continued in more indented lines
+		last := p.inside(i, ln.indent)
+		return last, p.rcvr.FinalBlock(p.src, p.rangeOf(i, last))
+	}
+	seqAtIndent := isEntry && !isItem && nextLn.indent == ln.indent && nextLn.isItem()
+	if nextLn.indent <= ln.indent && !seqAtIndent {
+		return i, p.rcvr.FinalBlock(p.src, p.rangeOf(i, i))
+	}
+
+	node := sparser.Node{Kind: "mapping", Name: key}
+	if nextLn.isItem() && len(value) == 0 {
+		node.Kind = "sequence"
+	}
+	if isItem && len(value) > 0 {
+		// a mapping in a sequence, starting with an entry
+		node.Name = ""
+	}
+	if err := sparser.StartNode(p.rcvr, p.src, p.rangeOf(i, i), node); err != nil {
+		return i, err
+	}
+	p.levels = append(p.levels, level{
+		indent:      ln.indent,
+		seqAtIndent: seqAtIndent,
+	})
+	return i, nil
+}
+
+// itemMapping sends the item of a sequence at lines[i], which is a mapping
+// starting with the entry of key and continued in the following lines, as a
+// level. indent is the column of its entries. Returns the index of the last
+// line of the first entry.
+func (p *parser) itemMapping(i int, key string, value []byte, indent int) (int, error) {
+	if err := sparser.StartNode(p.rcvr, p.src, p.rangeOf(i, i), sparser.Node{Kind: "mapping"}); err != nil {
+		return i, err
+	}
+	p.levels = append(p.levels, level{indent: p.lines[i].indent})
+
+	if len(value) > 0 && (value[0] == '{' || value[0] == '[') && flowDepth(value) > 0 {
+		return p.flow(i, key, sparser.Range{})
+	}
+	if len(value) > 0 {
+		// a block scalar, or a scalar continued in more indented lines
+		last := p.inside(i, indent)
+		if last == i {
+			return i, nil
+		}
+		first := i + 1
+		for p.lines[first].blank {
+			first++
+		}
+		return last, p.rcvr.FinalBlock(p.src, p.rangeOf(first, last))
+	}
+
+	next := &p.lines[p.next(i)]
+	seqAtIndent := next.indent == indent && next.isItem()
+	if next.indent <= indent && !seqAtIndent {
+		return i, nil
+	}
+	node := sparser.Node{Kind: "mapping", Name: key}
+	if next.isItem() {
+		node.Kind = "sequence"
+	}
+	if err := sparser.StartNode(p.rcvr, p.src, sparser.Range{}, node); err != nil {
+		return i, err
+	}
+	p.levels = append(p.levels, level{
+		indent:      indent,
+		seqAtIndent: seqAtIndent,
+	})
+	return i, nil
+}
+
+// Parse reports each document as a level, whose header is the "---" line if
+// any, and the mappings and sequences with nested lines as levels.
+func (Parser) Parse(in io.Reader, rcvr sparser.Receiver) error {
+	src, err := ioutil.ReadAll(in)
+	if err != nil {
+		return err
+	}
+
+	p := &parser{
+		src:   src,
+		rcvr:  rcvr,
+		lines: splitLines(src),
+	}
+	inDocument := false
+	for i := 0; i < len(p.lines); i++ {
+		ln := &p.lines[i]
+		switch {
+		case ln.blank:
+			continue
+		case ln.isMarker("---"):
+			if inDocument {
+				if err := p.closeDocument(sparser.Range{}); err != nil {
+					return err
+				}
+			}
+			if err := sparser.StartNode(rcvr, src, p.rangeOf(i, i), sparser.Node{Kind: "document"}); err != nil {
+				return err
+			}
+			inDocument = true
+			continue
+		case ln.isMarker("...") && inDocument:
+			if err := p.closeDocument(p.rangeOf(i, i)); err != nil {
+				return err
+			}
+			inDocument = false
+			continue
+		case !inDocument && (ln.text[0] == '%' || ln.isComment() || ln.isMarker("...")):
+			// directives and comments before the document
+			if err := rcvr.FinalBlock(src, p.rangeOf(i, i)); err != nil {
+				return err
+			}
+			continue
+		}
+
+		if !inDocument {
+			if err := sparser.StartNode(rcvr, src, sparser.Range{}, sparser.Node{Kind: "document"}); err != nil {
+				return err
+			}
+			inDocument = true
+		}
+		if ln.isComment() {
+			if err := rcvr.FinalBlock(src, p.rangeOf(i, i)); err != nil {
+				return err
+			}
+			continue
+		}
+		if err := p.closeLevels(ln); err != nil {
+			return err
+		}
+		if i, err = p.entry(i); err != nil {
+			return err
+		}
+	}
+	if inDocument {
+		return p.closeDocument(sparser.Range{})
+	}
+	return nil
+}
@@ -0,0 +1,156 @@
+package yaml
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/daviddengcn/go-assert"
+	"github.com/daviddengcn/go-villa"
+	"github.com/daviddengcn/sgrep/parser"
+)
+
+func parse(t *testing.T, src string) string {
+	act := ""
+	rcvr := sparser.ReceiverFunc{
+		StartLevelFunc: func(buffer []byte, header sparser.Range) error {
+			if header.IsEmpty() {
+				act += "S\n"
+				return nil
+			}
+			act += fmt.Sprintf("%d: ", header.MinLine)
+			act += "S " + string(buffer[header.MinOffs:header.MaxOffs+1]) + "\n"
+			return nil
+		},
+
+		FinalBlockFunc: func(buffer []byte, body sparser.Range) error {
+			if body.IsEmpty() {
+				return nil
+			}
+			act += fmt.Sprintf("%d: ", body.MinLine)
+			act += "F " + string(buffer[body.MinOffs:body.MaxOffs+1]) + "\n"
+			return nil
+		},
+
+		EndLevelFunc: func(buffer []byte, footer sparser.Range) error {
+			if footer.IsEmpty() {
+				act += "E\n"
+				return nil
+			}
+			act += fmt.Sprintf("%d: ", footer.MinLine)
+			act += "E " + string(buffer[footer.MinOffs:footer.MaxOffs+1]) + "\n"
+			return nil
+		},
+	}
+
+	srcBytes := villa.ByteSlice(src)
+	assert.NoError(t, Parser{}.Parse(&srcBytes, rcvr))
+	return act
+}
+
+func TestBasic(t *testing.T) {
+	src := `# comment
+apiVersion: v1
+spec:
+  containers:
+  - name: nginx
+    image: nginx:1.14
+    # ports
+    ports:
+    - containerPort: 80
+  - name: sidecar
+  script: |
+    echo hello
+      world
+
+  labels: {app: web}
+  args: [
+    "a",
+    "b"
+  ]
+description: a long
+  plain text
+---
+- a
+- b
+...
+`
+
+	exp := `1: F # comment
+S
+2: F apiVersion: v1
+3: S spec:
+4: S containers:
+5: S - name: nginx
+6: F image: nginx:1.14
+7: F # ports
+8: S ports:
+9: F - containerPort: 80
+E
+E
+10: F - name: sidecar
+E
+11: F script: |
+    echo hello
+      world
+15: F labels: {app: web}
+16: S args: [
+17: F "a",
+18: F "b"
+19: E ]
+E
+20: F description: a long
+  plain text
+E
+22: S ---
+23: F - a
+24: F - b
+25: E ...
+`
+	assert.TextEquals(t, "act", parse(t, src), exp)
+}
+
+func TestSequenceOfMappings(t *testing.T) {
+	src := `jobs:
+  build:
+    steps:
+    - run: |
+        echo hi
+        echo there
+      name: first
+    - uses: actions/checkout@v4
+      with:
+        fetch-depth: 0
+    - env:
+        A: 1
+      run: make
+    - name: last
+`
+
+	exp := `S
+1: S jobs:
+2: S build:
+3: S steps:
+4: S - run: |
+5: F echo hi
+        echo there
+7: F name: first
+E
+8: S - uses: actions/checkout@v4
+9: S with:
+10: F fetch-depth: 0
+E
+E
+11: S - env:
+S
+12: F A: 1
+E
+13: F run: make
+E
+14: F - name: last
+E
+E
+E
+E
+`
+	assert.TextEquals(t, "act", parse(t, src), exp)
+}
@@ -0,0 +1,130 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"regexp"
+	"regexp/syntax"
+	"strings"
+
+	"github.com/daviddengcn/sgrep/grep"
+)
+
+// stringList is a flag.Value collecting a repeatable string flag.
+type stringList []string
+
+func (l *stringList) String() string {
+	return strings.Join(*l, ",")
+}
+
+func (l *stringList) Set(s string) error {
+	*l = append(*l, s)
+	return nil
+}
+
+// readPatterns returns the patterns in file fn, one per line. Empty lines are
+// ignored.
+func readPatterns(fn string) ([]string, error) {
+	content, err := ioutil.ReadFile(fn)
+	if err != nil {
+		return nil, err
+	}
+	var pats []string
+	for _, line := range strings.Split(string(content), "\n") {
+		line = strings.TrimSuffix(line, "\r")
+		if line != "" {
+			pats = append(pats, line)
+		}
+	}
+	return pats, nil
+}
+
+// patternOptions are the flags changing how a pattern is interpreted.
+type patternOptions struct {
+	// POSIX ERE syntax with leftmost-longest semantics instead of RE2 syntax.
+	posix      bool
+	ignoreCase bool
+	word       bool
+	fixed      bool
+}
+
+// compilePattern compiles a pattern in RE2 syntax, or POSIX ERE syntax with
+// leftmost-longest semantics if opts.posix is set.
+func compilePattern(pat string, opts patternOptions) (*regexp.Regexp, error) {
+	flags := syntax.Perl
+	if opts.posix {
+		flags = syntax.POSIX
+	}
+	if opts.fixed {
+		flags |= syntax.Literal
+	}
+	if opts.ignoreCase {
+		flags |= syntax.FoldCase
+	}
+	sre, err := syntax.Parse(pat, flags)
+	if err != nil {
+		return nil, err
+	}
+	if opts.word {
+		sre = &syntax.Regexp{
+			Op: syntax.OpConcat,
+			Sub: []*syntax.Regexp{
+				&syntax.Regexp{Op: syntax.OpWordBoundary},
+				sre,
+				&syntax.Regexp{Op: syntax.OpWordBoundary},
+			},
+		}
+	}
+	// The parsed pattern is printed in Perl syntax, so the flags above are kept.
+	re, err := regexp.Compile(sre.String())
+	if err != nil {
+		return nil, err
+	}
+	if opts.posix {
+		re.Longest()
+	}
+	return re, nil
+}
+
+// compileEach compiles each of pats.
+func compileEach(pats []string, opts patternOptions) ([]grep.Matcher, error) {
+	ms := make([]grep.Matcher, 0, len(pats))
+	for _, pat := range pats {
+		re, err := compilePattern(pat, opts)
+		if err != nil {
+			return nil, fmt.Errorf("invalid pattern %q: %v", pat, err)
+		}
+		ms = append(ms, re)
+	}
+	return ms, nil
+}
+
+// compilePatterns returns a Matcher matching any of pats. For a single pattern
+// the *regexp.Regexp is returned.
+func compilePatterns(pats []string, opts patternOptions) (grep.Matcher, error) {
+	ms, err := compileEach(pats, opts)
+	if err != nil {
+		return nil, err
+	}
+	if len(ms) == 1 {
+		return ms[0], nil
+	}
+	return grep.MultiMatcher(ms), nil
+}
+
+// compileQuery returns a Query selecting the levels containing all of pats
+// and none of notPats.
+func compileQuery(pats, notPats []string, opts patternOptions) (*grep.Query, error) {
+	all, err := compileEach(pats, opts)
+	if err != nil {
+		return nil, err
+	}
+	none, err := compileEach(notPats, opts)
+	if err != nil {
+		return nil, err
+	}
+	return &grep.Query{
+		All:  all,
+		None: none,
+	}, nil
+}
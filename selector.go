@@ -0,0 +1,169 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/daviddengcn/sgrep/grep"
+)
+
+// selectorStep is a step of a selector in the form of [name:]value, where
+// value is either a glob or a /regexp/.
+type selectorStep struct {
+	name     string
+	value    string
+	isRegexp bool
+}
+
+// regexpEnd returns the index of the '/' closing the regexp started at s[0],
+// or -1 if not found.
+func regexpEnd(s string) int {
+	for i := 1; i < len(s); i++ {
+		switch s[i] {
+		case '\\':
+			i++
+		case '/':
+			return i
+		}
+	}
+	return -1
+}
+
+func parseSelectorSteps(s string) (steps []selectorStep, ok bool) {
+	for {
+		s = strings.TrimLeft(s, " \t")
+		var step selectorStep
+		if i := strings.IndexAny(s, ":/> \t"); i > 0 && s[i] == ':' {
+			step.name, s = s[:i], s[i+1:]
+		}
+		if strings.HasPrefix(s, "/") {
+			end := regexpEnd(s)
+			if end < 0 {
+				return nil, false
+			}
+			step.value, step.isRegexp = s[1:end], true
+			s = s[end+1:]
+		} else {
+			end := strings.IndexAny(s, "> \t")
+			if end < 0 {
+				end = len(s)
+			}
+			step.value, s = s[:end], s[end:]
+		}
+		if step.value == "" {
+			return nil, false
+		}
+		steps = append(steps, step)
+
+		s = strings.TrimLeft(s, " \t")
+		if s == "" {
+			return steps, true
+		}
+		if s[0] != '>' {
+			return nil, false
+		}
+		s = s[1:]
+	}
+}
+
+func isWordByte(b byte) bool {
+	return b == '_' || b >= '0' && b <= '9' || b >= 'a' && b <= 'z' || b >= 'A' && b <= 'Z'
+}
+
+// globToRegexp converts a glob of a word, where '*' and '?' match any word
+// characters, into a regexp.
+func globToRegexp(glob string) string {
+	var re []string
+	if isWordByte(glob[0]) || glob[0] == '*' || glob[0] == '?' {
+		re = append(re, `\b`)
+	}
+	for _, part := range strings.SplitAfter(glob, "") {
+		switch part {
+		case "*":
+			re = append(re, `\w*`)
+		case "?":
+			re = append(re, `\w`)
+		default:
+			re = append(re, regexp.QuoteMeta(part))
+		}
+	}
+	if last := glob[len(glob)-1]; isWordByte(last) || last == '*' || last == '?' {
+		re = append(re, `\b`)
+	}
+	return strings.Join(re, "")
+}
+
+// headerRegexp returns the regexp matching the headers selected by step.
+func (step selectorStep) headerRegexp() string {
+	value := step.value
+	if !step.isRegexp {
+		value = globToRegexp(value)
+	}
+	if step.name == "" {
+		return value
+	}
+	return globToRegexp(step.name) + `.*` + value
+}
+
+// selector, given with --select, restricts the matches of a pattern to the
+// inside of levels with matching headers, e.g.
+//   func:Handle* > /ctx\.Done/
+//   dependencies > dependency > artifactId:/mockito/
+// Each step but the last one selects the headers with a word matching a glob,
+// or a /regexp/. A name: prefix further requires a word matching name before
+// it. The last step is the /regexp/ to search, with an optional name: prefix
+// selecting its enclosing level.
+type selector struct {
+	// regexps of the headers of enclosing levels, from the outermost
+	within []string
+	// the pattern searched inside them
+	pattern string
+}
+
+// parseSelector parses pat as a selector. Returns false if pat is not a
+// selector, i.e. its last step is not a /regexp/ or it has neither a '>' nor
+// a name: prefix.
+func parseSelector(pat string) (*selector, bool) {
+	steps, ok := parseSelectorSteps(pat)
+	if !ok {
+		return nil, false
+	}
+	last := steps[len(steps)-1]
+	if !last.isRegexp || len(steps) == 1 && last.name == "" {
+		return nil, false
+	}
+
+	sel := &selector{
+		pattern: last.value,
+	}
+	for _, step := range steps[:len(steps)-1] {
+		sel.within = append(sel.within, step.headerRegexp())
+	}
+	if last.name != "" {
+		sel.within = append(sel.within, globToRegexp(last.name))
+	}
+	return sel, true
+}
+
+// selectorOf parses the patterns given with --select. Without --select, a
+// pattern is never taken as a selector, e.g. a:/b/ is searched as is.
+func selectorOf(pats []string, opts patternOptions) (*selector, error) {
+	if len(pats) != 1 || opts.fixed {
+		return nil, errors.New("--select takes exactly one pattern and cannot be used with -F")
+	}
+	sel, ok := parseSelector(pats[0])
+	if !ok {
+		return nil, fmt.Errorf("%q is not a selector", pats[0])
+	}
+	return sel, nil
+}
+
+// compileWithin compiles the header regexps of sel. Only the ignore-case
+// option of opts applies.
+func (sel *selector) compileWithin(opts patternOptions) ([]grep.Matcher, error) {
+	return compileEach(sel.within, patternOptions{
+		ignoreCase: opts.ignoreCase,
+	})
+}
@@ -4,24 +4,39 @@ import (
 	"flag"
 	"fmt"
 	"os"
-	"regexp"
+	"runtime"
+	"strconv"
 	"strings"
+	"sync/atomic"
 
 	"github.com/daviddengcn/go-ljson-conf"
 	"github.com/daviddengcn/go-villa"
 	"github.com/daviddengcn/sgrep/grep"
+	"github.com/daviddengcn/sgrep/parser"
+	"github.com/daviddengcn/sgrep/parser/go"
 )
 
 func init() {
 	flag.Usage = func() {
-		fmt.Fprintf(os.Stderr, "Usage: sgrep <pattern> [files]\n")
+		fmt.Fprintf(os.Stderr, "Usage: sgrep [options] <pattern> [files]\n")
+		fmt.Fprintf(os.Stderr, "       sgrep [options] -e <pattern> ... [files]\n")
+		fmt.Fprintf(os.Stderr, "       sgrep [options] -f <file> ... [files]\n")
+		fmt.Fprintf(os.Stderr, "With --select, a pattern like 'func:Handle* > /ctx\\.Done/' is a selector, matching\n")
+		fmt.Fprintf(os.Stderr, "/ctx\\.Done/ only inside levels whose headers match the steps before it.\n")
 		flag.PrintDefaults()
 	}
 }
 
+// Exit status, as grep does.
+const (
+	EXIT_MATCHED   = 0
+	EXIT_NOT_FOUND = 1
+	EXIT_ERROR     = 2
+)
+
 func printUsage() {
 	flag.Usage()
-	os.Exit(1)
+	os.Exit(EXIT_ERROR)
 }
 
 func removeLeadingDot(ext string) string {
@@ -62,35 +77,308 @@ func loadExtAlias() map[string]string {
 	return res
 }
 
+// displayName returns the name of fn shown to users.
+func displayName(fn villa.Path) string {
+	if fn == "" {
+		return grep.STDIN_NAME
+	}
+	return string(fn)
+}
+
+// countBlocks returns the number of blocks of results. The results in the
+// header and the footer of a level are of the same block.
+func countBlocks(results []*grep.Result) int {
+	n := 0
+	levels := make(map[*grep.Level]bool)
+	for _, r := range results {
+		if r.In == grep.IN_BLOCK {
+			n++
+			continue
+		}
+		if level := r.Levels[len(r.Levels)-1]; !levels[level] {
+			levels[level] = true
+			n++
+		}
+	}
+	return n
+}
+
+// blockDepth is a flag.Value of the depth of the enclosing level printed for
+// each match. It could be set without a value, meaning the innermost one.
+type blockDepth int
+
+func (d *blockDepth) String() string {
+	return fmt.Sprint(int(*d))
+}
+
+func (d *blockDepth) Set(s string) error {
+	if s == "true" {
+		*d = 1
+		return nil
+	}
+	n, err := strconv.Atoi(s)
+	if err != nil || n < 1 {
+		return fmt.Errorf("invalid depth %q", s)
+	}
+	*d = blockDepth(n)
+	return nil
+}
+
+func (d *blockDepth) IsBoolFlag() bool {
+	return true
+}
+
+// output is the buffered output of a searched file.
+type output struct {
+	buf   grep.Buffer
+	found bool
+	err   error
+}
+
+// job is a file to be searched by a worker.
+type job struct {
+	fn  villa.Path
+	ext string
+	// the output of the file is sent here when the search is done
+	done chan *output
+}
+
+// searcher searches a file, or stdin if fn is empty, and prints the results.
+// Returns whether the file is selected.
+type searcher func(fn villa.Path, ext string, out grep.Output) (bool, error)
+
+// grepFiles searches the files sent through fns with up to n concurrent
+// workers. The output of each file is buffered and printed to out in the order
+// of fns. Returns whether any file is selected.
+func grepFiles(search searcher, fns <-chan job, n int, out grep.Output) (found bool) {
+	if n < 1 {
+		n = 1
+	}
+	jobs := make(chan job)
+	// jobs started but not yet printed, in order
+	pending := make(chan job, 2*n)
+	go func() {
+		for j := range fns {
+			j.done = make(chan *output, 1)
+			pending <- j
+			jobs <- j
+		}
+		close(jobs)
+		close(pending)
+	}()
+
+	for i := 0; i < n; i++ {
+		go func() {
+			for j := range jobs {
+				out := &output{}
+				out.found, out.err = search(j.fn, j.ext, &out.buf)
+				j.done <- out
+			}
+		}()
+	}
+
+	for j := range pending {
+		o := <-j.done
+		o.buf.Flush(out)
+		if o.err != nil {
+			errorf("%v", o.err)
+		}
+		found = found || o.found
+	}
+	return found
+}
+
+// exitCode returns the exit status, as grep does. Any error reported wins over
+// found.
+func exitCode(found bool) int {
+	switch {
+	case atomic.LoadInt32(&failed) != 0:
+		return EXIT_ERROR
+	case found:
+		return EXIT_MATCHED
+	}
+	return EXIT_NOT_FOUND
+}
+
 func main() {
 	aliases := loadExtAlias()
 
 	pExt := flag.String("ext", "", "Specify the extension. If not specified, extract from filename")
+	var w walker
+	flag.BoolVar(&w.recursive, "r", false, "Search directories recursively. Defaults to current directory if no files given")
+	flag.Var(&w.includes, "include", "Search only files whose base name matches `GLOB`. A GLOB with a slash matches the path relative to the searched directory. Can be repeated")
+	flag.Var(&w.excludes, "exclude", "Skip files whose base name matches `GLOB`. A GLOB with a slash matches the path relative to the searched directory. Can be repeated")
+	flag.Var(&w.excludeDirs, "exclude-dir", "Skip directories whose base name matches `GLOB` while recursing. A GLOB with a slash matches the path relative to the searched directory. Can be repeated")
+	flag.BoolVar(&w.noIgnore, "no-ignore", false, "Don't skip files ignored by .gitignore or .sgrepignore files")
+	var goFilter goparser.Filter
+	flag.StringVar(&goFilter.GOOS, "goos", "", "Skip Go files excluded by build constraints for `OS`")
+	pTags := flag.String("tags", "", "Skip Go files excluded by build constraints with the comma-separated build `TAGS`")
+	flag.BoolVar(&goFilter.NoTests, "no-tests", false, "Skip Go _test.go files")
+	flag.BoolVar(&goFilter.SkipGenerated, "skip-generated", false, "Skip generated Go files, marked with a \"// Code generated ... DO NOT EDIT.\" comment")
+	pJobs := flag.Int("j", runtime.NumCPU(), "Maximum number of files searched concurrently")
+	pJSON := flag.Bool("json", false, "Print a JSON object per line for each match")
+	var pats, patFiles stringList
+	flag.Var(&pats, "e", "Use `PATTERN` for matching. Can be repeated, blocks matching any pattern are selected")
+	flag.Var(&patFiles, "f", "Read patterns from `FILE`, one per line. Can be repeated")
+	pAll := flag.Bool("all", false, "Select the innermost levels containing all of the patterns, instead of blocks matching any")
+	var notPats stringList
+	flag.Var(&notPats, "not", "Select the innermost levels containing the patterns but not `PATTERN`. Can be repeated")
+	pSelect := flag.Bool("select", false, "Interpret the pattern as a selector restricting matches to levels with matching headers, e.g. 'func:Handle* > /ctx\\.Done/'")
+	var popts patternOptions
+	flag.BoolVar(&popts.posix, "posix", false, "Use POSIX ERE syntax with leftmost-longest matching instead of RE2 syntax")
+	flag.BoolVar(&popts.ignoreCase, "i", false, "Ignore case distinctions")
+	flag.BoolVar(&popts.word, "w", false, "Match only whole words")
+	flag.BoolVar(&popts.fixed, "F", false, "Interpret the pattern as a fixed string")
+	pInvert := flag.Bool("v", false, "Select final blocks without any match")
+	pCount := flag.Bool("c", false, "Print only the number of matching blocks of each file. Matches in the header and footer of a level count as one block")
+	pWithFilename := flag.Bool("H", false, "Print the file name with the count of -c, even when searching a single file")
+	pFilesWith := flag.Bool("l", false, "Print only the names of files with matches")
+	pFilesWithout := flag.Bool("L", false, "Print only the names of files without any match")
+	var printer grep.Printer
+	flag.BoolVar(&printer.ShowOmitted, "omitted", false, "Print a summary line in place of the blocks omitted between printed ones")
+	pGroupMethods := flag.Bool("group-methods", false, "Show Go methods grouped under their receiver types")
+	var block blockDepth
+	flag.BoolVar(&printer.Breadcrumb, "breadcrumb", false, "Print each matching line in one line, prefixed with the path of its enclosing levels")
+	flag.Var(&block, "block", "Print the whole innermost level enclosing each match. With --block=N, the Nth innermost one")
 
 	flag.Parse()
+	printer.Block = int(block)
+	if *pTags != "" {
+		goFilter.Tags = strings.Split(*pTags, ",")
+	}
+	if goFilter.GOOS != "" || len(goFilter.Tags) > 0 || goFilter.NoTests || goFilter.SkipGenerated {
+		w.goFilter = &goFilter
+	}
+	if *pGroupMethods {
+		sparser.Register("go", func() (sparser.Parser, error) {
+			return goparser.Parser{GroupMethods: true}, nil
+		})
+	}
 
 	*pExt = findExtAlias(aliases, removeLeadingDot(*pExt))
 
 	args := flag.Args()
-	if len(args) < 1 {
-		printUsage()
+	for _, fn := range patFiles {
+		filePats, err := readPatterns(fn)
+		if err != nil {
+			errorf("%v", err)
+			os.Exit(EXIT_ERROR)
+		}
+		pats = append(pats, filePats...)
+	}
+	if len(pats) == 0 && len(patFiles) == 0 {
+		// the first argument is the pattern
+		if len(args) < 1 {
+			printUsage()
+		}
+		pats, args = args[:1], args[1:]
 	}
 
-	pat := flag.Arg(0)
-	fns := villa.Paths(args[1:]...)
+	fns := villa.Paths(args...)
+	if len(fns) == 0 && w.recursive {
+		fns = villa.Paths(".")
+	}
 
-	re := regexp.MustCompilePOSIX(pat)
+	// as grep, counts are prefixed by the file names only when searching
+	// more than one file
+	withFilename := *pWithFilename || len(fns) > 1 || len(fns) == 1 && w.recursive && fns[0].IsDir()
 
-	if len(fns) > 0 {
-		for _, fn := range fns {
-			ext := *pExt
-			if ext == "" {
-				ext = findExtAlias(aliases, removeLeadingDot(fn.Ext()))
-			}
+	var within []grep.Matcher
+	if *pSelect {
+		sel, err := selectorOf(pats, popts)
+		if err == nil {
+			within, err = sel.compileWithin(popts)
+		}
+		if err != nil {
+			errorf("%v", err)
+			os.Exit(EXIT_ERROR)
+		}
+		pats = []string{sel.pattern}
+	}
+
+	m, err := compilePatterns(pats, popts)
+	if err != nil {
+		errorf("%v", err)
+		os.Exit(EXIT_ERROR)
+	}
 
-			grep.Grep(re, fn, ext)
+	searcher := &grep.Searcher{
+		Matcher: m,
+		Invert:  *pInvert,
+		Within:  within,
+	}
+	if *pInvert && *pJSON {
+		// inverted results have no matches to print
+		errorf("-v cannot be used with --json")
+		os.Exit(EXIT_ERROR)
+	}
+	if *pAll || len(notPats) > 0 {
+		if *pInvert {
+			errorf("-v cannot be used with --all or --not")
+			os.Exit(EXIT_ERROR)
+		}
+		if searcher.Query, err = compileQuery(pats, notPats, popts); err != nil {
+			errorf("%v", err)
+			os.Exit(EXIT_ERROR)
 		}
+	}
+	// matches are not highlighted in inverted results
+	highlight := m
+	if *pInvert {
+		highlight = nil
+	}
+	search := func(fn villa.Path, ext string, out grep.Output) (bool, error) {
+		results, err := searcher.SearchFile(fn, ext)
+		if err != nil {
+			return false, err
+		}
+		found := len(results) > 0
+		switch {
+		case *pCount:
+			if withFilename {
+				fmt.Fprintf(out, "%v:", displayName(fn))
+			}
+			fmt.Fprintln(out, countBlocks(results))
+		case *pFilesWith:
+			if len(results) > 0 {
+				fmt.Fprintln(out, displayName(fn))
+			}
+		case *pFilesWithout:
+			// a listed file is taken as selected
+			found = !found
+			if found {
+				fmt.Fprintln(out, displayName(fn))
+			}
+		case *pJSON:
+			return found, grep.PrintJSON(out, fn, results)
+		default:
+			printer.Print(out, fn, highlight, results)
+		}
+		return found, nil
+	}
+
+	found := false
+	if len(fns) > 0 {
+		jobs := make(chan job)
+		go func() {
+			for _, root := range fns {
+				w.walk(root, func(fn villa.Path) {
+					ext := *pExt
+					if ext == "" {
+						ext = findExtAlias(aliases, removeLeadingDot(fn.Ext()))
+					}
+
+					jobs <- job{fn: fn, ext: ext}
+				})
+			}
+			close(jobs)
+		}()
+		found = grepFiles(search, jobs, *pJobs, grep.Stdout)
 	} else {
-		grep.Grep(re, "", *pExt)
+		if found, err = search("", *pExt, grep.Stdout); err != nil {
+			errorf("%v", err)
+		}
 	}
+
+	os.Exit(exitCode(found))
 }
@@ -1,8 +1,317 @@
 package main
 
 import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"sync/atomic"
 	"testing"
+	"time"
+
+	"github.com/daviddengcn/go-assert"
+	"github.com/daviddengcn/go-colortext"
+	"github.com/daviddengcn/go-villa"
+	"github.com/daviddengcn/sgrep/grep"
+	"github.com/daviddengcn/sgrep/parser/go"
 )
 
 func Test(t *testing.T) {
 }
+
+// writeFiles creates the files (with contents) under a new temporary folder.
+// Returns the root of the folder.
+func writeFiles(t *testing.T, files map[string]string) villa.Path {
+	root, err := ioutil.TempDir("", "sgrep")
+	if err != nil {
+		t.Fatalf("TempDir failed: %v", err)
+	}
+	for fn, content := range files {
+		fn = filepath.Join(root, filepath.FromSlash(fn))
+		if err := os.MkdirAll(filepath.Dir(fn), 0755); err != nil {
+			t.Fatalf("MkdirAll failed: %v", err)
+		}
+		if err := ioutil.WriteFile(fn, []byte(content), 0644); err != nil {
+			t.Fatalf("WriteFile failed: %v", err)
+		}
+	}
+	return villa.Path(root)
+}
+
+// walkedFiles returns the slash separated paths, relative to root, visited by w
+// when walking start.
+func walkedFiles(w *walker, root, start villa.Path) string {
+	var fns []string
+	w.walk(start, func(fn villa.Path) {
+		rel, _ := filepath.Rel(string(root), string(fn))
+		fns = append(fns, filepath.ToSlash(rel))
+	})
+	return strings.Join(fns, " ")
+}
+
+func TestWalk(t *testing.T) {
+	root := writeFiles(t, map[string]string{
+		"a.go":          "package a",
+		"b.xml":         "<b/>",
+		"bin.dat":       "\x00\x01",
+		"sub/c.go":      "package c",
+		"sub/d.json":    "{}",
+		"vendor/e.go":   "package e",
+		"vendor/x/f.go": "package f",
+	})
+	defer os.RemoveAll(string(root))
+
+	assert.Equals(t, "not recursive", walkedFiles(&walker{}, root, root), "")
+	assert.Equals(t, "recursive", walkedFiles(&walker{recursive: true}, root, root),
+		"a.go b.xml sub/c.go sub/d.json vendor/e.go vendor/x/f.go")
+	assert.Equals(t, "include", walkedFiles(&walker{
+		recursive: true,
+		includes:  globList{"*.go"},
+	}, root, root), "a.go sub/c.go vendor/e.go vendor/x/f.go")
+	assert.Equals(t, "exclude", walkedFiles(&walker{
+		recursive: true,
+		excludes:  globList{"*.go", "d.*"},
+	}, root, root), "b.xml")
+	assert.Equals(t, "exclude-dir", walkedFiles(&walker{
+		recursive:   true,
+		excludeDirs: globList{"vendor"},
+	}, root, root), "a.go b.xml sub/c.go sub/d.json")
+
+	// files given explicitly are searched even if they look binary
+	assert.Equals(t, "file", walkedFiles(&walker{}, root, villa.Path(filepath.Join(string(root), "bin.dat"))), "bin.dat")
+}
+
+func TestWalkSlashGlob(t *testing.T) {
+	root := writeFiles(t, map[string]string{
+		"sub/a.go":     "package a",
+		"sub/x/b.go":   "package b",
+		"sub/x/y/c.go": "package c",
+	})
+	defer os.RemoveAll(string(root))
+
+	wd, err := os.Getwd()
+	assert.NoError(t, err)
+	assert.NoError(t, os.Chdir(string(root)))
+	defer os.Chdir(wd)
+
+	// globs with a slash are matched against the paths relative to the walk
+	// root, excluding the root itself
+	assert.Equals(t, "include", walkedFiles(&walker{
+		recursive: true,
+		includes:  globList{"x/*.go"},
+	}, ".", "./sub"), "sub/x/b.go")
+	assert.Equals(t, "exclude", walkedFiles(&walker{
+		recursive: true,
+		excludes:  globList{"sub/*.go", "x/y/*"},
+	}, ".", "./sub"), "sub/a.go sub/x/b.go")
+	assert.Equals(t, "exclude-dir", walkedFiles(&walker{
+		recursive:   true,
+		excludeDirs: globList{"x/y"},
+	}, ".", "./sub"), "sub/a.go sub/x/b.go")
+}
+
+func TestWalkGoFilter(t *testing.T) {
+	root := writeFiles(t, map[string]string{
+		"a.go":         "package a",
+		"a_test.go":    "package a",
+		"a_windows.go": "package a",
+		"b.go":         "//go:build integration\n\npackage a",
+		"pb.go":        "// Code generated by protoc-gen-go. DO NOT EDIT.\n\npackage a",
+		"c.json":       "{}",
+	})
+	defer os.RemoveAll(string(root))
+
+	walk := func(filter goparser.Filter) string {
+		return walkedFiles(&walker{recursive: true, goFilter: &filter}, root, root)
+	}
+	assert.Equals(t, "no-tests", walk(goparser.Filter{NoTests: true}), "a.go a_windows.go b.go c.json pb.go")
+	assert.Equals(t, "skip-generated", walk(goparser.Filter{SkipGenerated: true}), "a.go a_test.go a_windows.go b.go c.json")
+	assert.Equals(t, "goos", walk(goparser.Filter{GOOS: "linux"}), "a.go a_test.go c.json pb.go")
+	assert.Equals(t, "tags", walk(goparser.Filter{GOOS: "windows", Tags: []string{"integration"}}), "a.go a_test.go a_windows.go b.go c.json pb.go")
+}
+
+func TestIgnoreRules(t *testing.T) {
+	rules := parseIgnoreRules([]byte(`# comment
+*.log
+!keep.log
+/build/
+docs/**/gen
+foo\ 
+\#hash
+`))
+	l := ignoreList{&ignoreFile{rules: rules}}
+	ignored := func(fn string, isDir bool) bool {
+		return l.ignored(strings.Split(fn, "/"), isDir)
+	}
+	assert.Equals(t, "a.log", ignored("a.log", false), true)
+	assert.Equals(t, "sub/a.log", ignored("sub/a.log", false), true)
+	assert.Equals(t, "keep.log", ignored("sub/keep.log", false), false)
+	assert.Equals(t, "build dir", ignored("build", true), true)
+	assert.Equals(t, "build file", ignored("build", false), false)
+	assert.Equals(t, "sub/build", ignored("sub/build", true), false)
+	assert.Equals(t, "docs/gen", ignored("docs/gen", false), true)
+	assert.Equals(t, "docs/a/b/gen", ignored("docs/a/b/gen", false), true)
+	assert.Equals(t, "sub/docs/gen", ignored("sub/docs/gen", false), false)
+	assert.Equals(t, "foo ", ignored("foo ", false), true)
+	assert.Equals(t, "#hash", ignored("#hash", false), true)
+	assert.Equals(t, "comment", ignored("# comment", false), false)
+}
+
+func TestWalkIgnore(t *testing.T) {
+	root := writeFiles(t, map[string]string{
+		".git/config":          "",
+		".gitignore":           "vendor/\n*.gen.json\n",
+		"a.go":                 "package a",
+		"a.gen.json":           "{}",
+		"vendor/b.go":          "package b",
+		"sub/.gitignore":       "!*.gen.json\n",
+		"sub/c.gen.json":       "{}",
+		"sub/d.xml":            "<d/>",
+		"sub/.sgrepignore":     "*.xml\n",
+		"sub/inner/e.xml":      "<e/>",
+		"sub/inner/f.gen.json": "{}",
+	})
+	defer os.RemoveAll(string(root))
+
+	assert.Equals(t, "ignore", walkedFiles(&walker{recursive: true}, root, root),
+		".gitignore a.go sub/.gitignore sub/.sgrepignore sub/c.gen.json sub/inner/f.gen.json")
+	assert.Equals(t, "no-ignore", walkedFiles(&walker{recursive: true, noIgnore: true}, root, root),
+		".git/config .gitignore a.gen.json a.go sub/.gitignore sub/.sgrepignore sub/c.gen.json sub/d.xml sub/inner/e.xml sub/inner/f.gen.json vendor/b.go")
+
+	// ignore files in the parents of the walk root are honored
+	sub := villa.Path(filepath.Join(string(root), "sub"))
+	assert.Equals(t, "parent", walkedFiles(&walker{recursive: true}, root, sub),
+		"sub/.gitignore sub/.sgrepignore sub/c.gen.json sub/inner/f.gen.json")
+}
+
+func TestCompilePattern(t *testing.T) {
+	find := func(pat string, opts patternOptions, text string) string {
+		re, err := compilePattern(pat, opts)
+		if err != nil {
+			return "error"
+		}
+		return fmt.Sprint(re.FindAllString(text, -1))
+	}
+	assert.Equals(t, "plain", find("ab|abc", patternOptions{}, "abcd"), "[ab]")
+	assert.Equals(t, "posix", find("ab|abc", patternOptions{posix: true}, "abcd"), "[abc]")
+	assert.Equals(t, "perl", find(`(?i)\d+?x`, patternOptions{}, "12X"), "[12X]")
+	assert.Equals(t, "perl in posix", find(`\d`, patternOptions{posix: true}, "1"), "error")
+	assert.Equals(t, "posix ignore case", find("ab|abc", patternOptions{posix: true, ignoreCase: true}, "ABCD"), "[ABC]")
+	assert.Equals(t, "ignore case", find("ab", patternOptions{ignoreCase: true}, "Ab aB"), "[Ab aB]")
+	assert.Equals(t, "word", find("foo", patternOptions{word: true}, "foobar foo"), "[foo]")
+	assert.Equals(t, "fixed", find("a.b", patternOptions{fixed: true}, "axb a.b"), "[a.b]")
+	assert.Equals(t, "fixed ignore case", find("A.", patternOptions{fixed: true, ignoreCase: true}, "a. ab"), "[a.]")
+	assert.Equals(t, "invalid", find("a(", patternOptions{}, ""), "error")
+}
+
+func TestParseSelector(t *testing.T) {
+	sel, ok := parseSelector(`func:Handle* > /ctx\.Done/`)
+	assert.IsTrue(t, "ok", ok)
+	assert.StringEquals(t, "within", sel.within, []string{`\bfunc\b.*\bHandle\w*\b`})
+	assert.Equals(t, "pattern", sel.pattern, `ctx\.Done`)
+
+	sel, ok = parseSelector(`dependencies > dependency>artifactId:/mock ito/`)
+	assert.IsTrue(t, "ok", ok)
+	assert.StringEquals(t, "within", sel.within, []string{`\bdependencies\b`, `\bdependency\b`, `\bartifactId\b`})
+	assert.Equals(t, "pattern", sel.pattern, `mock ito`)
+
+	sel, ok = parseSelector(`/a\/b/ > x:/c/`)
+	assert.IsTrue(t, "ok", ok)
+	assert.StringEquals(t, "within", sel.within, []string{`a\/b`, `\bx\b`})
+
+	for _, pat := range []string{`x > 0`, `/abc/`, `a > /b`, `a >> /b/`, `a > /b/ c`, ``} {
+		_, ok := parseSelector(pat)
+		assert.IsFalse(t, pat, ok)
+	}
+}
+
+func TestSelectorOf(t *testing.T) {
+	sel, err := selectorOf([]string{`a:/b/`}, patternOptions{})
+	assert.NoError(t, err)
+	assert.Equals(t, "pattern", sel.pattern, "b")
+
+	_, err = selectorOf([]string{`/abc/`}, patternOptions{})
+	assert.IsTrue(t, "not a selector", err != nil)
+	_, err = selectorOf([]string{`a:/b/`, `c`}, patternOptions{})
+	assert.IsTrue(t, "two patterns", err != nil)
+	_, err = selectorOf([]string{`a:/b/`}, patternOptions{fixed: true})
+	assert.IsTrue(t, "fixed", err != nil)
+}
+
+// textOutput is an Output ignoring colors.
+type textOutput struct {
+	text string
+}
+
+func (o *textOutput) Write(p []byte) (int, error) {
+	o.text += string(p)
+	return len(p), nil
+}
+
+func (o *textOutput) ChangeColor(fg ct.Color, fgBright bool) {}
+
+func (o *textOutput) ResetColor() {}
+
+func TestGrepFiles(t *testing.T) {
+	// file name -> parse time, or negative for a failure
+	delays := map[villa.Path]time.Duration{
+		"a": 30 * time.Millisecond,
+		"b": 0,
+		"c": -20 * time.Millisecond,
+		"d": 10 * time.Millisecond,
+	}
+	search := func(fn villa.Path, ext string, out grep.Output) (bool, error) {
+		d := delays[fn]
+		if d < 0 {
+			time.Sleep(-d)
+			return false, fmt.Errorf("%s: failed", fn)
+		}
+		time.Sleep(d)
+		fmt.Fprintln(out, fn)
+		return fn == "d", nil
+	}
+	grepAll := func(fns ...villa.Path) (string, int) {
+		// errors reported by earlier tests
+		atomic.StoreInt32(&failed, 0)
+
+		jobs := make(chan job)
+		go func() {
+			for _, fn := range fns {
+				jobs <- job{fn: fn}
+			}
+			close(jobs)
+		}()
+		out := &textOutput{}
+		found := grepFiles(search, jobs, len(fns), out)
+		return out.text, exitCode(found)
+	}
+
+	text, code := grepAll("a", "b", "d")
+	assert.Equals(t, "output", text, "a\nb\nd\n")
+	assert.Equals(t, "matched", code, EXIT_MATCHED)
+
+	text, code = grepAll("d", "c", "b", "a")
+	assert.Equals(t, "output", text, "d\nb\na\n")
+	assert.Equals(t, "error with matches", code, EXIT_ERROR)
+
+	text, code = grepAll("a", "b")
+	assert.Equals(t, "output", text, "a\nb\n")
+	assert.Equals(t, "not found", code, EXIT_NOT_FOUND)
+}
+
+func TestCountBlocks(t *testing.T) {
+	src := `<project>
+  <dependency>
+    <id>a</id>
+  </dependency>
+  <dependency>
+    <id>b</id>
+  </dependency>
+</project>
+`
+	results, err := grep.Search(strings.NewReader(src), "xml", regexp.MustCompile("dependency"))
+	assert.NoError(t, err)
+	assert.Equals(t, "countBlocks", countBlocks(results), 2)
+}
@@ -0,0 +1,167 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+
+	"github.com/daviddengcn/go-villa"
+	"github.com/daviddengcn/sgrep/parser/go"
+)
+
+// globList is a flag.Value collecting a repeatable glob flag.
+type globList []string
+
+func (l *globList) String() string {
+	return strings.Join(*l, ",")
+}
+
+func (l *globList) Set(glob string) error {
+	if _, err := filepath.Match(glob, ""); err != nil {
+		return fmt.Errorf("invalid glob %q: %v", glob, err)
+	}
+	*l = append(*l, glob)
+	return nil
+}
+
+// match returns true if any glob matches the base name of fn. Globs containing
+// a slash are matched against rel, the slash separated path of fn relative to
+// the walk root, instead.
+func (l globList) match(fn villa.Path, rel string) bool {
+	base := filepath.Base(string(fn))
+	for _, glob := range l {
+		name := base
+		if strings.ContainsRune(glob, '/') {
+			name = rel
+		}
+		if ok, _ := filepath.Match(glob, name); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// walker expands the command line paths into the list of files to search.
+type walker struct {
+	recursive   bool
+	includes    globList
+	excludes    globList
+	excludeDirs globList
+	// If set, .gitignore and .sgrepignore files are not honored.
+	noIgnore bool
+	// If not nil, Go files not matched are skipped.
+	goFilter *goparser.Filter
+}
+
+// failed is set to non-zero once any error is reported by errorf.
+var failed int32
+
+// errorf prints an error message to stderr and records the failure for the
+// exit status.
+func errorf(format string, args ...interface{}) {
+	fmt.Fprintf(os.Stderr, "sgrep: "+format+"\n", args...)
+	atomic.StoreInt32(&failed, 1)
+}
+
+// acceptFile returns true if the file fn, with the path rel relative to the
+// walk root, is to be searched.
+func (w *walker) acceptFile(fn villa.Path, rel string) bool {
+	if len(w.includes) > 0 && !w.includes.match(fn, rel) {
+		return false
+	}
+	if w.excludes.match(fn, rel) {
+		return false
+	}
+	if w.goFilter != nil && filepath.Ext(string(fn)) == ".go" {
+		ok, err := w.goFilter.Match(string(fn))
+		if err != nil {
+			errorf("%v", err)
+		}
+		return ok
+	}
+	return true
+}
+
+// isBinary reports whether the file looks like a binary file, i.e. there is a
+// NUL byte in its first few kilobytes.
+func isBinary(fn villa.Path) bool {
+	f, err := fn.Open()
+	if err != nil {
+		return false
+	}
+	defer f.Close()
+
+	var buf [8192]byte
+	n, _ := f.Read(buf[:])
+	for _, b := range buf[:n] {
+		if b == 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// walk calls visit for each file to be searched under fn. Files named on the
+// command line are always visited (unless excluded), directories are only
+// descended into if recursive is set. While walking, files ignored by the
+// .gitignore or .sgrepignore files in the walked folders, or in their parents
+// inside the same git repository, are skipped unless noIgnore is set.
+func (w *walker) walk(fn villa.Path, visit func(fn villa.Path)) {
+	info, err := os.Stat(string(fn))
+	if err != nil {
+		errorf("%v", err)
+		return
+	}
+	if !info.IsDir() {
+		// a file is its own walk root, so its path is matched as given
+		if w.acceptFile(fn, filepath.ToSlash(filepath.Clean(string(fn)))) {
+			visit(fn)
+		}
+		return
+	}
+	if !w.recursive {
+		errorf("%s: Is a directory", fn)
+		return
+	}
+	var ignores ignoreList
+	if !w.noIgnore {
+		ignores = loadParentIgnores(string(fn))
+	}
+	w.walkDir(fn, nil, ignores, visit)
+}
+
+// segs are the path segments of dir relative to the walk root.
+func (w *walker) walkDir(dir villa.Path, segs []string, ignores ignoreList, visit func(fn villa.Path)) {
+	infos, err := ioutil.ReadDir(string(dir))
+	if err != nil {
+		errorf("%v", err)
+		return
+	}
+	if !w.noIgnore {
+		ignores = ignores.load(string(dir), nil, len(segs))
+	}
+	for _, info := range infos {
+		fn := villa.Path(filepath.Join(string(dir), info.Name()))
+		subSegs := append(segs[:len(segs):len(segs)], info.Name())
+		if !w.noIgnore && (info.Name() == ".git" || ignores.ignored(subSegs, info.IsDir())) {
+			continue
+		}
+		rel := strings.Join(subSegs, "/")
+		switch {
+		case info.IsDir():
+			if w.excludeDirs.match(fn, rel) {
+				continue
+			}
+			w.walkDir(fn, subSegs, ignores, visit)
+		case info.Mode().IsRegular():
+			if !w.acceptFile(fn, rel) || isBinary(fn) {
+				continue
+			}
+			visit(fn)
+		}
+		// symlinks and other special files found while walking are skipped.
+	}
+}